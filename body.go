@@ -0,0 +1,347 @@
+package hfs
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// maxDrainBytes is how much of an unread body is discarded to keep the connection alive, a larger
+// rest closes the connection instead
+const maxDrainBytes = 256 << 10
+
+// body streams the request body from the connection following its framing, it's shared by the
+// copies of a request
+type body struct {
+	reader io.Reader
+	// request is used for the errors, it's the request without its body
+	request Request
+	// limit is the number of bytes that can still be read before MaxBodyBytes is exceeded
+	limit int64
+	eof   bool
+	err   error
+	// closed is set by Close, reading a closed body fails
+	closed bool
+	// content is the whole body once it's read by [Request.ReadBody]
+	content []byte
+	cached  bool
+	// onRead is called before the first read, onEOF once the body is fully read
+	onRead func()
+	onEOF  func()
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, NewServerError("Read on closed body")
+	}
+
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	if b.eof {
+		return 0, io.EOF
+	}
+
+	if b.onRead != nil {
+		b.onRead()
+		b.onRead = nil
+	}
+
+	// read one byte over the limit to know it's exceeded, MaxInt64 is no limit and can't grow
+	if b.limit < math.MaxInt64 && int64(len(p)) > b.limit+1 {
+		p = p[:b.limit+1]
+	}
+
+	n, err := b.reader.Read(p)
+	b.limit -= int64(n)
+
+	if b.limit < 0 {
+		b.err = NewHttpError(413, "Content Too Large", b.request)
+		return n + int(b.limit), b.err
+	}
+
+	if err == io.EOF {
+		b.setEOF()
+	} else if err != nil {
+		b.err = b.httpError(err)
+		return n, b.err
+	}
+
+	return n, err
+}
+
+func (b *body) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (b *body) setEOF() {
+	b.eof = true
+
+	if b.onEOF != nil {
+		b.onEOF()
+		b.onEOF = nil
+	}
+}
+
+// whenRead calls f once the body is fully read, right away if it's already
+func (b *body) whenRead(f func()) {
+	if b.eof {
+		f()
+		return
+	}
+
+	b.onEOF = f
+}
+
+// drain discards the rest of the body and reports whether the connection can read the next
+// request after it
+func (b *body) drain() bool {
+	if b.eof {
+		return true
+	}
+
+	// the client is still waiting for 100 Continue, it may never send the body
+	if b.err != nil || b.onRead != nil {
+		return false
+	}
+
+	// the handler is done, nothing waits for the end of the body anymore
+	b.closed = false
+	b.onEOF = nil
+	io.CopyN(io.Discard, b, maxDrainBytes)
+
+	return b.eof
+}
+
+// httpError maps a read error to the [HttpError] answered to the client
+func (b *body) httpError(err error) error {
+	if _, ok := err.(*HttpError); ok {
+		return err
+	}
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return NewHttpError(408, "Request Timeout", b.request)
+	}
+
+	if err == io.ErrUnexpectedEOF {
+		return NewHttpError(400, "Incomplete body", b.request)
+	}
+
+	return err
+}
+
+// badBodyError answers a body that can't be parsed with 400, the errors of reading the body like
+// 413 are returned as they are
+func badBodyError(r *Request, prefix string, err error) error {
+	if httpError, ok := err.(*HttpError); ok {
+		return httpError
+	}
+
+	return NewHttpError(400, prefix+": "+err.Error(), *r)
+}
+
+// lengthReader reads a body framed by Content-Length
+type lengthReader struct {
+	reader    *bufio.Reader
+	remaining int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	// report the end with the last bytes so the body doesn't need another read
+	if err == nil && l.remaining == 0 {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// chunkedReader decodes a chunked body, trailer fields are merged into the request headers
+type chunkedReader struct {
+	reader  *bufio.Reader
+	request Request
+	option  *Option
+	// remaining is what's left of the current chunk, its CRLF is read with the next size line
+	remaining int64
+	started   bool
+	done      bool
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	for cr.remaining == 0 {
+		if cr.done {
+			return 0, io.EOF
+		}
+
+		err := cr.nextChunk()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+
+	n, err := cr.reader.Read(p)
+	cr.remaining -= int64(n)
+
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// nextChunk reads the size line of the next chunk, or the trailers after the last chunk
+func (cr *chunkedReader) nextChunk() error {
+	// the data of the previous chunk ends with CRLF
+	if cr.started {
+		crlf := make([]byte, 2)
+		_, err := io.ReadFull(cr.reader, crlf)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+
+		if crlf[0] != '\r' || crlf[1] != '\n' {
+			return NewHttpError(400, "Invalid chunk data", cr.request)
+		}
+	}
+
+	cr.started = true
+
+	line, err := readLine(cr.reader, maxChunkLineLength)
+	if err == errLineTooLong || err == errMalformedLine {
+		return NewHttpError(400, "Invalid chunk size", cr.request)
+	}
+
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+
+	// ignore chunk extensions
+	if i := strings.IndexByte(line, ';'); i != -1 {
+		line = line[:i]
+	}
+
+	// only hex digits are allowed, ParseInt would also accept a sign
+	line = strings.TrimRight(line, " \t")
+	if strings.TrimLeft(line, "0123456789abcdefABCDEF") != "" {
+		return NewHttpError(400, "Invalid chunk size", cr.request)
+	}
+
+	size, err := strconv.ParseInt(line, 16, 64)
+	if err != nil || size < 0 {
+		return NewHttpError(400, "Invalid chunk size", cr.request)
+	}
+
+	// last chunk
+	if size == 0 {
+		cr.done = true
+		return cr.readTrailers()
+	}
+
+	cr.remaining = size
+
+	return nil
+}
+
+// readTrailers reads the trailers until the empty line, they are limited like the headers
+func (cr *chunkedReader) readTrailers() error {
+	headerBytes := limitOf(cr.option.MaxHeaderBytes)
+
+	for count := 0; ; count++ {
+		line, err := readLine(cr.reader, headerBytes)
+		if err == errLineTooLong {
+			return NewHttpError(431, "Request Header Fields Too Large", cr.request)
+		}
+
+		if err == errMalformedLine {
+			return NewHttpError(400, "Malformed trailer", cr.request)
+		}
+
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+
+		if line == "" {
+			return nil
+		}
+
+		headerBytes -= len(line)
+
+		if count >= limitOf(cr.option.MaxHeaderCount) {
+			return NewHttpError(431, "Too Many Request Header Fields", cr.request)
+		}
+
+		key, value, ok := parseHeaderLine(line)
+		if !ok {
+			return NewHttpError(400, "Malformed trailer", cr.request)
+		}
+
+		// framing fields are not allowed in trailers
+		if strings.EqualFold(key, "Content-Length") || strings.EqualFold(key, "Transfer-Encoding") {
+			continue
+		}
+
+		cr.request.Headers.Add(key, value)
+	}
+}
+
+// chunkedWriter encodes every write as a chunk and flushes it so the client gets the body while
+// it's produced, the last chunk is written by [writeResponse]
+type chunkedWriter struct {
+	writer *bufio.Writer
+}
+
+func (cw *chunkedWriter) Write(p []byte) (int, error) {
+	// an empty chunk would end the body
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	cw.writer.Write(strconv.AppendInt(cw.writer.AvailableBuffer(), int64(len(p)), 16))
+	cw.writer.WriteString("\r\n")
+
+	n, err := cw.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	cw.writer.WriteString("\r\n")
+
+	return n, cw.writer.Flush()
+}
+
+// flushWriter flushes every write so the client gets the body while it's produced
+type flushWriter struct {
+	writer *bufio.Writer
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	return n, fw.writer.Flush()
+}
@@ -0,0 +1,238 @@
+package hfs
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBodyLimit(t *testing.T) {
+	option := testOption
+	option.MaxBodyBytes = 5
+
+	tests := []struct {
+		name    string
+		request string
+		body    string
+		code    int
+	}{
+		{"content length at the limit", "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello", "hello", 0},
+		{"content length over the limit", "POST / HTTP/1.1\r\nContent-Length: 6\r\n\r\nhello!", "", 413},
+		{"chunked at the limit", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhe\r\n3\r\nllo\r\n0\r\n\r\n", "hello", 0},
+		{"chunked over the limit", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhe\r\n4\r\nllo!\r\n0\r\n\r\n", "", 413},
+		{"single chunk over the limit", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n10\r\n0123456789abcdef\r\n0\r\n\r\n", "", 413},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// a declared length over the limit is rejected before the body is read
+			request, _, err := parseRawConn(t, &option, test.request)
+			if err != nil {
+				if code := errorCode(err); code != test.code {
+					t.Errorf("error = %v, want code %d", err, test.code)
+				}
+
+				return
+			}
+
+			body, err := request.ReadBody()
+			if string(body) != test.body {
+				t.Errorf("body = %q, want %q", body, test.body)
+			}
+
+			if code := errorCode(err); code != test.code {
+				t.Errorf("error = %v, want code %d", err, test.code)
+			}
+
+			// the error sticks to the body
+			if test.code != 0 {
+				if _, err := request.ReadBody(); errorCode(err) != test.code {
+					t.Errorf("second read error = %v, want code %d", err, test.code)
+				}
+			}
+		})
+	}
+}
+
+func TestBodyNoLimit(t *testing.T) {
+	option := testOption
+	option.MaxBodyBytes = -1
+
+	t.Run("read", func(t *testing.T) {
+		request, _, err := parseRawConn(t, &option, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := request.ReadBody()
+		if err != nil || string(body) != "hello" {
+			t.Errorf("ReadBody = %q, %v, want \"hello\"", body, err)
+		}
+	})
+
+	t.Run("unread", func(t *testing.T) {
+		request, c, err := parseRawConn(t, &option,
+			"POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhelloGET /next HTTP/1.1\r\n\r\n",
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !request.body.drain() {
+			t.Fatal("drain failed")
+		}
+
+		next, err := parseRequest(c, &option)
+		if err != nil || next.Path != "/next" {
+			t.Errorf("next request = %q, %v, want /next", next.Path, err)
+		}
+	})
+}
+
+func TestBodyDrain(t *testing.T) {
+	large := strings.Repeat("a", maxDrainBytes+1)
+
+	tests := []struct {
+		name string
+		// read is how much of the body the handler reads
+		read      int
+		request   string
+		keepAlive bool
+	}{
+		{"unread content length", 0, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello", true},
+		{"partly read content length", 2, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello", true},
+		{"unread chunked", 0, "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\nX-T: 1\r\n\r\n", true},
+		{"empty", 0, "POST / HTTP/1.1\r\n\r\n", true},
+		{"too large", 0, "POST / HTTP/1.1\r\nContent-Length: " + strconv.Itoa(len(large)) + "\r\n\r\n" + large, false},
+		// the client waits for 100 Continue before it sends the body
+		{"expect continue", 0, "POST / HTTP/1.1\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\nhello", false},
+		{"malformed chunked", 0, "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, c, err := parseRawConn(t, &testOption, test.request, "GET /next HTTP/1.1\r\n\r\n")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.read > 0 {
+				request.BodyReader().Read(make([]byte, test.read))
+			}
+
+			if keepAlive := request.body.drain(); keepAlive != test.keepAlive {
+				t.Fatalf("drain = %v, want %v", keepAlive, test.keepAlive)
+			}
+
+			if !test.keepAlive {
+				return
+			}
+
+			next, err := parseRequest(c, &testOption)
+			if err != nil || next.Path != "/next" {
+				t.Errorf("next request = %q, %v, want /next", next.Path, err)
+			}
+		})
+	}
+}
+
+func TestBodyReadOnce(t *testing.T) {
+	request, _, err := parseRawConn(t, &testOption, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body, _ := request.ReadBody(); string(body) != "hello" {
+		t.Fatalf("ReadBody = %q, want \"hello\"", body)
+	}
+
+	// the body is kept for the next reads
+	if body, _ := request.ReadBody(); string(body) != "hello" {
+		t.Errorf("second ReadBody = %q, want \"hello\"", body)
+	}
+
+	buf := make([]byte, 10)
+	if n, _ := request.BodyReader().Read(buf); string(buf[:n]) != "hello" {
+		t.Errorf("BodyReader after ReadBody = %q, want \"hello\"", buf[:n])
+	}
+}
+
+func TestBodyExpectContinue(t *testing.T) {
+	_, address := startServer(t, Option{}, func(s *Server) {
+		s.Handle("/", func(r Request) *Response {
+			body, err := r.ReadBody()
+			if err != nil {
+				return NewErrorResponse(err)
+			}
+
+			return NewTextResponse(string(body))
+		})
+	})
+
+	c, reader := dial(t, address)
+	c.Write([]byte("POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n"))
+
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "HTTP/1.1 100 Continue\r\n" {
+		t.Fatalf("status line = %q, %v, want 100 Continue", line, err)
+	}
+
+	reader.ReadString('\n')
+	c.Write([]byte("hello"))
+
+	if response, body := readResponse(t, reader, "POST"); response.StatusCode != 200 || body != "hello" {
+		t.Errorf("response = %d %q, want 200 \"hello\"", response.StatusCode, body)
+	}
+}
+
+func TestBodyReadTimeout(t *testing.T) {
+	_, address := startServer(t, Option{ReadTimeout: 100 * time.Millisecond}, func(s *Server) {
+		s.Handle("/", func(r Request) *Response {
+			if _, err := r.ReadBody(); err != nil {
+				return NewErrorResponse(err)
+			}
+
+			return NewTextResponse("ok")
+		})
+	})
+
+	c, reader := dial(t, address)
+	c.Write([]byte("POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 10\r\n\r\nhello"))
+
+	response, _ := readResponse(t, reader, "POST")
+	if response.StatusCode != 408 {
+		t.Errorf("status = %d, want 408", response.StatusCode)
+	}
+
+	if !response.Close {
+		t.Error("connection is kept alive after a body timeout")
+	}
+}
+
+func TestBodyContextCancelledAfterBody(t *testing.T) {
+	cancelled := make(chan bool, 1)
+	_, address := startServer(t, Option{}, func(s *Server) {
+		s.Handle("/", func(r Request) *Response {
+			r.ReadBody()
+
+			select {
+			case <-r.Context.Done():
+				cancelled <- true
+			case <-time.After(time.Second):
+				cancelled <- false
+			}
+
+			return NewTextResponse("ok")
+		})
+	})
+
+	c, _ := dial(t, address)
+	c.Write([]byte("POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello"))
+	time.Sleep(50 * time.Millisecond)
+	c.Close()
+
+	if !<-cancelled {
+		t.Error("context wasn't cancelled when the client went away")
+	}
+}
@@ -132,27 +132,27 @@ func main() {
 			if httpError.Code == http.StatusNotFound {
 				return &hfs.Response{
 					Code: 404,
-					Headers: map[string]string{
+					Headers: hfs.NewHeader(map[string]string{
 						"Content-Type": "text/plain",
-					},
+					}),
 					Body: "Not Found",
 				}
 			}
 
 			return &hfs.Response{
 				Code: httpError.Code,
-				Headers: map[string]string{
+				Headers: hfs.NewHeader(map[string]string{
 					"Content-Type": "text/plain",
-				},
+				}),
 				Body: httpError.Msg,
 			}
 		}
 
 		return &hfs.Response{
 			Code: 500,
-			Headers: map[string]string{
+			Headers: hfs.NewHeader(map[string]string{
 				"Content-Type": "text/plain",
-			},
+			}),
 			Body: "Internal Server Error",
 		}
 	})
@@ -210,9 +210,9 @@ func main() {
 
 		return &hfs.Response{
 			Code: 200,
-			Headers: map[string]string{
+			Headers: hfs.NewHeader(map[string]string{
 				"Content-Type": "text/plain",
-			},
+			}),
 			Body: "Websocket",
 		}
 	})
@@ -0,0 +1,125 @@
+package hfs
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// aLongTimeAgo is a deadline in the past, it's used to unblock a pending read
+var aLongTimeAgo = time.Unix(1, 0)
+
+// connection states, they tell [Server.Shutdown] how to handle the connection
+const (
+	stateActive int32 = iota
+	stateIdle
+	stateHijacked
+)
+
+// conn holds the state of a client connection, it's shared by every request served on it
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+	// ctx is cancelled when the connection is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+	// hijacked is set when the connection is taken over from the server, e.g. by a websocket upgrade
+	hijacked bool
+	state    atomic.Int32
+	// goingAway is set once the server sent the websocket close frame on shutdown
+	goingAway bool
+	// bodyDeadline is the read deadline once the request headers are read
+	bodyDeadline time.Time
+	// tempFiles are the multipart files of the current request, they are removed once the request
+	// is done
+	tempFiles []string
+
+	backgroundDone    chan struct{}
+	backgroundAborted atomic.Bool
+}
+
+func newConn(parent context.Context, c net.Conn) *conn {
+	ctx, cancel := context.WithCancel(parent)
+
+	conn := &conn{
+		Conn:   c,
+		reader: bufio.NewReader(c),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	// nothing is read yet
+	conn.state.Store(stateIdle)
+
+	return conn
+}
+
+// startBackgroundRead watches the connection while a handler runs, cancel is called when the
+// client goes away. A pipelined request ends the watch without consuming it
+func (c *conn) startBackgroundRead(cancel context.CancelFunc) {
+	c.backgroundDone = make(chan struct{})
+	c.backgroundAborted.Store(false)
+
+	go func() {
+		defer close(c.backgroundDone)
+
+		_, err := c.reader.Peek(1)
+		if err != nil && !c.backgroundAborted.Load() {
+			cancel()
+		}
+	}()
+}
+
+// abortBackgroundRead stops the background read so the connection can be read again
+func (c *conn) abortBackgroundRead() {
+	if c.backgroundDone == nil {
+		return
+	}
+
+	c.backgroundAborted.Store(true)
+	c.SetReadDeadline(aLongTimeAgo)
+	<-c.backgroundDone
+	c.SetReadDeadline(time.Time{})
+
+	c.backgroundDone = nil
+}
+
+// removeTempFiles removes the temporary files created for the request
+func (c *conn) removeTempFiles() {
+	for _, file := range c.tempFiles {
+		os.Remove(file)
+	}
+
+	c.tempFiles = nil
+}
+
+// hijack takes the connection over from the server, it won't be read or written by the server
+// anymore
+func (c *conn) hijack() {
+	c.abortBackgroundRead()
+
+	// the server timeouts don't apply to the new owner
+	c.SetDeadline(time.Time{})
+
+	c.hijacked = true
+	c.state.Store(stateHijacked)
+}
+
+// shouldKeepAlive reports whether the connection can be reused after responding to the request.
+// HTTP/1.1 connections are persistent unless the client sends "Connection: close", HTTP/1.0
+// connections are closed unless the client opts in with "Connection: keep-alive"
+func shouldKeepAlive(request Request) bool {
+	connection := request.Headers.Get("Connection")
+
+	switch request.Version {
+	case "HTTP/1.1":
+		return !hasToken(connection, "close")
+	case "HTTP/1.0":
+		return hasToken(connection, "keep-alive")
+	default:
+		return false
+	}
+}
@@ -0,0 +1,144 @@
+package hfs
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TIME_FORMAT is the HTTP date format (RFC 7231 section 7.1.1.1), times must be in UTC
+const TIME_FORMAT = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// SameSite is the SameSite attribute of a cookie
+type SameSite int
+
+const (
+	SAME_SITE_DEFAULT SameSite = iota
+	SAME_SITE_LAX
+	SAME_SITE_STRICT
+	SAME_SITE_NONE
+)
+
+// Cookie is an HTTP cookie with the attributes of RFC 6265
+type Cookie struct {
+	Name   string
+	Value  string
+	Path   string
+	Domain string
+	// Expires is omitted when zero
+	Expires time.Time
+	// MaxAge is the lifetime in seconds, zero omits the attribute and a negative value deletes
+	// the cookie (Max-Age=0)
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+}
+
+// String returns the cookie as a Set-Cookie header value, or "" if the name is not a valid token
+func (c *Cookie) String() string {
+	if !isToken(c.Name) {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteString("=")
+	b.WriteString(sanitizeCookieValue(c.Value))
+
+	if path := sanitizeCookiePath(c.Path); path != "" {
+		b.WriteString("; Path=" + path)
+	}
+
+	// an invalid domain is left out, a cleaned one could widen the scope of the cookie
+	if domain := strings.TrimPrefix(c.Domain, "."); isCookieDomain(domain) {
+		b.WriteString("; Domain=" + domain)
+	}
+
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(TIME_FORMAT))
+	}
+
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+
+	switch c.SameSite {
+	case SAME_SITE_LAX:
+		b.WriteString("; SameSite=Lax")
+	case SAME_SITE_STRICT:
+		b.WriteString("; SameSite=Strict")
+	case SAME_SITE_NONE:
+		b.WriteString("; SameSite=None")
+	}
+
+	return b.String()
+}
+
+// sanitizeCookieValue drops the bytes that are not allowed in a cookie value, a value with a space
+// or a comma is quoted
+func sanitizeCookieValue(value string) string {
+	var b strings.Builder
+	quote := false
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == ' ' || c == ',':
+			quote = true
+		case c <= ' ' || c >= 0x7F || c == '"' || c == ';' || c == '\\':
+			continue
+		}
+
+		b.WriteByte(c)
+	}
+
+	if quote {
+		return `"` + b.String() + `"`
+	}
+
+	return b.String()
+}
+
+// sanitizeCookiePath drops the bytes a path attribute can't have, CTLs (including CR and LF)
+// and ";" would end the attribute or the header
+func sanitizeCookiePath(path string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c < ' ' || c >= 0x7F || c == ';' {
+			continue
+		}
+
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
+
+// isCookieDomain reports whether the domain only has the characters of a host name
+func isCookieDomain(domain string) bool {
+	if domain == "" || len(domain) > 255 {
+		return false
+	}
+
+	for i := 0; i < len(domain); i++ {
+		c := domain[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || isDigit(c) || c == '-' || c == '.') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,47 @@
+package hfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCookieString(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie Cookie
+		want   string
+	}{
+		{"name and value", Cookie{Name: "a", Value: "b"}, "a=b"},
+		{"invalid name", Cookie{Name: "a b", Value: "c"}, ""},
+		{"value with space", Cookie{Name: "a", Value: "b c"}, `a="b c"`},
+		{"value with separators", Cookie{Name: "a", Value: "b;\"c\\\r\n"}, "a=bc"},
+		{
+			"attributes",
+			Cookie{
+				Name:     "a",
+				Value:    "b",
+				Path:     "/app",
+				Domain:   ".example.com",
+				Expires:  time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+				MaxAge:   60,
+				HttpOnly: true,
+				Secure:   true,
+				SameSite: SAME_SITE_LAX,
+			},
+			"a=b; Path=/app; Domain=example.com; Expires=Fri, 02 Jan 2026 03:04:05 GMT; Max-Age=60; HttpOnly; Secure; SameSite=Lax",
+		},
+		{"deleted", Cookie{Name: "a", MaxAge: -1}, "a=; Max-Age=0"},
+		{"path with crlf", Cookie{Name: "a", Path: "/\r\nX-Injected: yes"}, "a=; Path=/X-Injected: yes"},
+		{"path with semicolon", Cookie{Name: "a", Path: "/a; Secure"}, "a=; Path=/a Secure"},
+		{"domain with crlf", Cookie{Name: "a", Domain: "example.com\r\nX-Injected: yes"}, "a="},
+		{"domain with semicolon", Cookie{Name: "a", Domain: "example.com; Secure"}, "a="},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.cookie.String(); got != test.want {
+				t.Errorf("String() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package hfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// DEFAULT_MULTIPART_MEMORY is the memory used for multipart files by [Request.ParseForm], larger
+// files are stored in temporary files
+const DEFAULT_MULTIPART_MEMORY = 32 << 20
+
+// MultipartForm is a parsed multipart/form-data body
+type MultipartForm struct {
+	Value Query
+	File  map[string][]*FileHeader
+}
+
+// FileHeader describes a file part of a multipart form
+type FileHeader struct {
+	Filename string
+	Header   Header
+	Size     int64
+
+	content []byte
+	tmpfile string
+}
+
+// File is the content of an uploaded file
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+type bytesFile struct {
+	*bytes.Reader
+}
+
+func (bytesFile) Close() error {
+	return nil
+}
+
+// Open opens the content of the file
+func (fh *FileHeader) Open() (File, error) {
+	if fh.tmpfile != "" {
+		return os.Open(fh.tmpfile)
+	}
+
+	return bytesFile{bytes.NewReader(fh.content)}, nil
+}
+
+// RemoveAll removes the temporary files of the form, the server also removes them once the
+// request is done
+func (f *MultipartForm) RemoveAll() error {
+	var err error
+	for _, files := range f.File {
+		for _, fh := range files {
+			if fh.tmpfile == "" {
+				continue
+			}
+
+			if e := os.Remove(fh.tmpfile); e != nil && !os.IsNotExist(e) && err == nil {
+				err = e
+			}
+		}
+	}
+
+	return err
+}
+
+// ParseForm parses an application/x-www-form-urlencoded body into Form, a multipart/form-data
+// body is parsed with [Request.ParseMultipartForm] using DEFAULT_MULTIPART_MEMORY. Other content
+// types leave Form empty. A malformed body returns a 400 [HttpError]
+func (r *Request) ParseForm() error {
+	if r.Form != nil {
+		return nil
+	}
+
+	mediaType, _ := parseMediaType(r.Headers.Get("Content-Type"))
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		content, err := r.ReadBody()
+		if err != nil {
+			return err
+		}
+
+		form, err := parseQuery(string(content))
+		if err != nil {
+			return NewHttpError(400, "Malformed form body", *r)
+		}
+
+		r.Form = form
+	case "multipart/form-data":
+		return r.ParseMultipartForm(DEFAULT_MULTIPART_MEMORY)
+	default:
+		r.Form = make(Query)
+	}
+
+	return nil
+}
+
+// FormValue returns the first value of the form field, or of the query parameter if the form
+// doesn't have it
+func (r *Request) FormValue(key string) string {
+	r.ParseForm()
+
+	if values := r.Form[key]; len(values) != 0 {
+		return values[0]
+	}
+
+	return r.Query().Get(key)
+}
+
+// MultipartReader returns a reader for a multipart/form-data body to stream its parts, use it
+// instead of [Request.ParseMultipartForm] to process the parts while they are read
+func (r *Request) MultipartReader() (*MultipartReader, error) {
+	mediaType, params := parseMediaType(r.Headers.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return nil, NewHttpError(415, "Content-Type is not multipart/form-data", *r)
+	}
+
+	boundary := params["boundary"]
+	if boundary == "" || len(boundary) > 70 {
+		return nil, NewHttpError(400, "Invalid multipart boundary", *r)
+	}
+
+	return NewMultipartReader(r.BodyReader(), boundary), nil
+}
+
+// ParseMultipartForm parses a multipart/form-data body into MultipartForm, the fields are also
+// added to Form. Up to maxMemory bytes of the files are kept in memory, the files that don't fit
+// are stored in temporary files
+func (r *Request) ParseMultipartForm(maxMemory int64) error {
+	if r.MultipartForm != nil {
+		return nil
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	form := &MultipartForm{
+		Value: make(Query),
+		File:  make(map[string][]*FileHeader),
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			form.RemoveAll()
+			return badBodyError(r, "Malformed multipart body", err)
+		}
+
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+
+		var buf bytes.Buffer
+
+		filename := part.FileName()
+		if filename == "" {
+			// fields are kept in memory, they share the memory limit with the files
+			n, err := io.CopyN(&buf, part, maxMemory+1)
+			if err != nil && err != io.EOF {
+				form.RemoveAll()
+				return badBodyError(r, "Malformed multipart body", err)
+			}
+
+			if n > maxMemory {
+				form.RemoveAll()
+				return NewHttpError(413, "Multipart form is too large", *r)
+			}
+
+			maxMemory -= n
+			form.Value[name] = append(form.Value[name], buf.String())
+			continue
+		}
+
+		fh := &FileHeader{
+			Filename: filename,
+			Header:   part.Header,
+		}
+
+		n, err := io.CopyN(&buf, part, maxMemory+1)
+		if err != nil && err != io.EOF {
+			form.RemoveAll()
+			return badBodyError(r, "Malformed multipart body", err)
+		}
+
+		if n > maxMemory {
+			// spill the file to disk
+			err = fh.writeTempFile(io.MultiReader(&buf, part))
+			if fh.tmpfile != "" && r.conn != nil {
+				r.conn.tempFiles = append(r.conn.tempFiles, fh.tmpfile)
+			}
+
+			if err != nil {
+				form.File[name] = append(form.File[name], fh)
+				form.RemoveAll()
+
+				return err
+			}
+		} else {
+			fh.content = buf.Bytes()
+			fh.Size = n
+			maxMemory -= n
+		}
+
+		form.File[name] = append(form.File[name], fh)
+	}
+
+	r.MultipartForm = form
+
+	if r.Form == nil {
+		r.Form = make(Query)
+	}
+
+	for key, values := range form.Value {
+		r.Form[key] = append(r.Form[key], values...)
+	}
+
+	return nil
+}
+
+func (fh *FileHeader) writeTempFile(content io.Reader) error {
+	file, err := os.CreateTemp("", "hfs-multipart-")
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	fh.tmpfile = file.Name()
+	fh.Size, err = io.Copy(file, content)
+
+	return err
+}
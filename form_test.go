@@ -0,0 +1,225 @@
+package hfs
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// formRequest parses a POST request with the body, it's sent in writes of odd sizes so the
+// boundaries are split across reads
+func formRequest(t *testing.T, contentType, body string) (Request, *conn) {
+	t.Helper()
+
+	raw := "POST /?q=query HTTP/1.1\r\nContent-Type: " + contentType + "\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+
+	var writes []string
+	for len(raw) > 777 {
+		writes = append(writes, raw[:777])
+		raw = raw[777:]
+	}
+
+	request, c, err := parseRawConn(t, &testOption, append(writes, raw)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return request, c
+}
+
+type testPart struct {
+	name     string
+	filename string
+	content  string
+}
+
+// multipartBody encodes the parts, content builds the content of a part from the boundary
+func multipartBody(t *testing.T, parts []testPart, content func(boundary string, i int) string) (string, string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	for i, part := range parts {
+		var pw io.Writer
+		var err error
+		if part.filename != "" {
+			pw, err = w.CreateFormFile(part.name, part.filename)
+		} else {
+			pw, err = w.CreateFormField(part.name)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		value := part.content
+		if content != nil && value == "" {
+			value = content(w.Boundary(), i)
+		}
+
+		pw.Write([]byte(value))
+	}
+
+	w.Close()
+
+	return w.FormDataContentType(), buf.String()
+}
+
+func TestParseFormURLEncoded(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		field string
+		want  []string
+		code  int
+	}{
+		{"fields", "a=1&b=2&a=3", "a", []string{"1", "3"}, 0},
+		{"escapes", "a=hello+world%21", "a", []string{"hello world!"}, 0},
+		{"empty", "", "a", nil, 0},
+		{"bad escape", "a=%zz", "", nil, 400},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, _ := formRequest(t, "application/x-www-form-urlencoded", test.body)
+
+			err := request.ParseForm()
+			if code := errorCode(err); code != test.code {
+				t.Fatalf("error = %v, want code %d", err, test.code)
+			}
+
+			if test.code != 0 {
+				return
+			}
+
+			if got := request.Form[test.field]; strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Errorf("Form[%s] = %q, want %q", test.field, got, test.want)
+			}
+
+			// the query is used for the fields the form doesn't have
+			if got := request.FormValue("q"); got != "query" {
+				t.Errorf("FormValue(q) = %q, want \"query\"", got)
+			}
+		})
+	}
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	large := func(boundary string, i int) string {
+		// delimiter look-alikes around the buffer size of the reader
+		chunk := strings.Repeat("x", 4090) + "\r\n--" + boundary[:10] + "\r\n-"
+		return strings.Repeat(chunk, 3)
+	}
+
+	parts := []testPart{
+		{name: "title", content: "hello"},
+		{name: "tricky", content: "a\r\n--b\r\n"},
+		{name: "tricky", content: ""},
+		{name: "doc", filename: "../../etc/passwd", content: "small file"},
+		{name: "doc", filename: `C:\Users\me\notes.txt`, content: "windows"},
+		{name: "big", filename: "big.bin", content: ""},
+	}
+
+	contentType, body := multipartBody(t, parts, func(boundary string, i int) string {
+		if parts[i].name == "tricky" {
+			return "\r\n--" + boundary[:len(boundary)-1] + "\r\n"
+		}
+
+		return large(boundary, i)
+	})
+
+	request, c := formRequest(t, contentType, body)
+
+	if err := request.ParseMultipartForm(1024); err != nil {
+		t.Fatal(err)
+	}
+
+	boundary := contentType[strings.Index(contentType, "boundary=")+len("boundary="):]
+
+	if got := request.FormValue("title"); got != "hello" {
+		t.Errorf("title = %q, want \"hello\"", got)
+	}
+
+	tricky := request.Form["tricky"]
+	if len(tricky) != 2 || tricky[0] != "a\r\n--b\r\n" || tricky[1] != "\r\n--"+boundary[:len(boundary)-1]+"\r\n" {
+		t.Errorf("tricky = %q", tricky)
+	}
+
+	docs := request.MultipartForm.File["doc"]
+	if len(docs) != 2 || docs[0].Filename != "passwd" || docs[1].Filename != "notes.txt" {
+		t.Fatalf("doc files = %+v, want passwd and notes.txt", docs)
+	}
+
+	if docs[0].tmpfile != "" {
+		t.Error("small file was written to a temporary file")
+	}
+
+	big := request.MultipartForm.File["big"][0]
+	if big.tmpfile == "" {
+		t.Fatal("file larger than maxMemory wasn't written to a temporary file")
+	}
+
+	file, err := big.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := io.ReadAll(file)
+	file.Close()
+
+	if want := large(boundary, 0); string(content) != want || big.Size != int64(len(want)) {
+		t.Errorf("big file has %d bytes, want %d", len(content), len(want))
+	}
+
+	// the server removes the temporary files once the response is written
+	if len(c.tempFiles) != 1 || c.tempFiles[0] != big.tmpfile {
+		t.Fatalf("tempFiles = %q, want %q", c.tempFiles, big.tmpfile)
+	}
+
+	c.removeTempFiles()
+	if _, err := os.Stat(big.tmpfile); !os.IsNotExist(err) {
+		t.Errorf("temporary file wasn't removed: %v", err)
+	}
+}
+
+func TestParseMultipartFormErrors(t *testing.T) {
+	fields := []testPart{
+		{name: "a", content: strings.Repeat("a", 600)},
+		{name: "b", content: strings.Repeat("b", 600)},
+	}
+
+	contentType, body := multipartBody(t, fields, nil)
+	smallType, small := multipartBody(t, fields[:1], nil)
+	truncated := small[:len(small)-10]
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		code        int
+	}{
+		{"fields over maxMemory", contentType, body, 413},
+		{"not multipart", "text/plain", body, 415},
+		{"missing boundary", "multipart/form-data", body, 400},
+		{"boundary too long", "multipart/form-data; boundary=" + strings.Repeat("b", 71), body, 400},
+		{"missing closing boundary", smallType, truncated, 400},
+		{"wrong boundary", "multipart/form-data; boundary=other", small, 400},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, _ := formRequest(t, test.contentType, test.body)
+
+			err := request.ParseMultipartForm(1000)
+			if code := errorCode(err); code != test.code {
+				t.Errorf("error = %v, want code %d", err, test.code)
+			}
+		})
+	}
+}
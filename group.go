@@ -0,0 +1,72 @@
+package hfs
+
+import "strings"
+
+// Group registers routes on a server under a shared path prefix, the group middleware only
+// runs for the routes of the group and its subgroups
+//
+//	api := server.Group("/api/v1", auth)
+//	api.Handle("GET /users/:id", ...) // GET /api/v1/users/:id
+type Group struct {
+	Prefix string
+
+	middleware []Middleware
+	server     *Server
+	parent     *Group
+}
+
+// Group creates a group of routes under the prefix
+func (s *Server) Group(prefix string, middleware ...MiddlewareHandler) *Group {
+	return &Group{
+		Prefix:     strings.TrimSuffix(prefix, "/"),
+		middleware: adaptMiddleware(middleware),
+		server:     s,
+	}
+}
+
+// Group creates a subgroup, its prefix is appended to the group prefix and its middleware runs
+// after the group middleware
+func (g *Group) Group(prefix string, middleware ...MiddlewareHandler) *Group {
+	group := g.server.Group(g.Prefix+prefix, middleware...)
+	group.parent = g
+
+	return group
+}
+
+// Use adds a middleware to the group, it also applies to routes registered before
+func (g *Group) Use(middleware MiddlewareHandler) *Group {
+	g.middleware = append(g.middleware, middleware.Middleware())
+
+	return g
+}
+
+// Wrap adds a middleware that wraps the handlers of the group, see [Middleware]
+func (g *Group) Wrap(middleware Middleware) *Group {
+	g.middleware = append(g.middleware, middleware)
+
+	return g
+}
+
+// Handle registers a handler for the path prefixed with the group prefix, see [Server.Handle].
+// The path "/" is the prefix itself, "GET /" of the "/api" group is "GET /api" and not "/api/"
+func (g *Group) Handle(
+	path string,
+	handler ResponseHandler,
+	middleware ...MiddlewareHandler,
+) error {
+	method, path := parsePath(path)
+	if path == "/" && g.Prefix != "" {
+		path = ""
+	}
+
+	return g.server.handle(method, g.Prefix+path, handler, middleware, g)
+}
+
+// middlewares returns the middleware of the group and its parents, from the outermost group
+func (g *Group) middlewares() []Middleware {
+	if g == nil {
+		return nil
+	}
+
+	return append(g.parent.middlewares(), g.middleware...)
+}
@@ -0,0 +1,33 @@
+package hfs
+
+import "testing"
+
+func TestGroupHandleRoot(t *testing.T) {
+	server := NewServer("localhost:0", Option{})
+	handler := func(Request) *Response { return NewResponse() }
+
+	api := server.Group("/api")
+	if err := api.Handle("GET /", handler); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := api.Group("/v1/").Handle("/", handler); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		match bool
+	}{
+		{"/api", true},
+		{"/api/", false},
+		{"/api/v1", true},
+		{"/api/v1/", false},
+	}
+
+	for _, test := range tests {
+		if n, _ := server.router.lookup(test.path); (n != nil) != test.match {
+			t.Errorf("lookup %s matched = %v, want %v", test.path, n != nil, test.match)
+		}
+	}
+}
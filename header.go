@@ -0,0 +1,109 @@
+package hfs
+
+import "strings"
+
+// Header holds the header fields of a request or response. Keys are canonicalized with
+// [CanonicalHeaderKey] so lookups ignore the case, and a field can have multiple values.
+//
+// Use [NewHeader] to build a Header from a map literal
+//
+//	hfs.NewHeader(map[string]string{"Content-Type": "text/plain"})
+type Header map[string][]string
+
+// NewHeader creates a Header with a single value for every field
+func NewHeader(fields map[string]string) Header {
+	header := make(Header, len(fields))
+	for key, value := range fields {
+		header.Set(key, value)
+	}
+
+	return header
+}
+
+// Get returns the first value of the field, or "" if it's not set
+func (h Header) Get(key string) string {
+	values := h[CanonicalHeaderKey(key)]
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// Values returns every value of the field
+func (h Header) Values(key string) []string {
+	return h[CanonicalHeaderKey(key)]
+}
+
+// Has reports whether the field is set
+func (h Header) Has(key string) bool {
+	_, ok := h[CanonicalHeaderKey(key)]
+	return ok
+}
+
+// Set replaces the values of the field with the value
+func (h Header) Set(key, value string) {
+	h[CanonicalHeaderKey(key)] = []string{value}
+}
+
+// Add appends the value to the values of the field
+func (h Header) Add(key, value string) {
+	key = CanonicalHeaderKey(key)
+	h[key] = append(h[key], value)
+}
+
+// Del removes the field
+func (h Header) Del(key string) {
+	delete(h, CanonicalHeaderKey(key))
+}
+
+// CanonicalHeaderKey returns the canonical form of the header key, the first letter and every
+// letter following a hyphen are upper case and the rest is lower case, e.g. "content-type"
+// becomes "Content-Type". Keys that aren't valid tokens are returned unchanged
+func CanonicalHeaderKey(key string) string {
+	if !isToken(key) {
+		return key
+	}
+
+	// avoid allocating when the key is already canonical
+	upper := true
+	canonical := true
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if (upper && c >= 'a' && c <= 'z') || (!upper && c >= 'A' && c <= 'Z') {
+			canonical = false
+			break
+		}
+
+		upper = c == '-'
+	}
+
+	if canonical {
+		return key
+	}
+
+	result := []byte(key)
+	upper = true
+	for i, c := range result {
+		if upper && c >= 'a' && c <= 'z' {
+			result[i] = c - ('a' - 'A')
+		} else if !upper && c >= 'A' && c <= 'Z' {
+			result[i] = c + ('a' - 'A')
+		}
+
+		upper = c == '-'
+	}
+
+	return string(result)
+}
+
+// hasToken reports whether the comma separated header value contains the token
+func hasToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+
+	return false
+}
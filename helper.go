@@ -1,101 +1,601 @@
 package hfs
 
 import (
-	"context"
+	"bufio"
+	"io"
+	"math"
 	"net"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 )
 
-func parseRequest(conn net.Conn) (request Request, err error) {
-	buf := make([]byte, 1024)
-	request.Conn = conn
-	request.Context = context.Background()
+// errors returned by [readLine]
+var (
+	errLineTooLong   = NewServerError("Line too long")
+	errMalformedLine = NewServerError("Line doesn't end with CRLF")
+)
+
+// maxChunkLineLength is the limit of a chunk size line including its extensions
+const maxChunkLineLength = 4096
+
+func parseRequest(c *conn, option *Option) (request Request, err error) {
+	reader := c.reader
+	request.Conn = c.Conn
+	request.conn = c
+	request.Context = c.ctx
+
+	// the request line and the headers share the MaxHeaderBytes budget
+	headerBytes := limitOf(option.MaxHeaderBytes)
+
+	line, err := readLine(reader, headerBytes)
+	if err == errLineTooLong {
+		return request, NewHttpError(414, "URI Too Long", request)
+	}
+
+	if err == errMalformedLine {
+		return request, NewHttpError(400, "Malformed request line", request)
+	}
 
-	_, err = conn.Read(buf)
 	if err != nil {
 		return request, err
 	}
 
-	stringBuf := string(buf)
+	headerBytes -= len(line)
 
-	sp := strings.Split(stringBuf, "\r\n")
+	target, err := parseRequestLine(line, &request)
+	if err != nil {
+		return request, err
+	}
 
-	requestLine := strings.Split(sp[0], " ")
-	request.Method = strings.ToUpper(requestLine[0])
-	request.Version = requestLine[2]
+	if len(target) > limitOf(option.MaxURILength) {
+		return request, NewHttpError(414, "URI Too Long", request)
+	}
 
-	request.Headers = make(map[string]string)
-	for i := 1; i < len(sp); i++ {
-		if sp[i] == "" {
-			request.Body = sp[i+1]
+	// read headers until the empty line (CRLFCRLF), it may span multiple reads
+	request.Headers = make(Header)
+	for count := 0; ; count++ {
+		line, err = readLine(reader, headerBytes)
+		if err == errLineTooLong {
+			return request, NewHttpError(431, "Request Header Fields Too Large", request)
+		}
+
+		if err == errMalformedLine {
+			return request, NewHttpError(400, "Malformed header", request)
+		}
+
+		if err != nil {
+			return request, err
+		}
+
+		if line == "" {
 			break
 		}
 
-		header := strings.Split(sp[i], ": ")
-		request.Headers[header[0]] = header[1]
+		headerBytes -= len(line)
+
+		if count >= limitOf(option.MaxHeaderCount) {
+			return request, NewHttpError(431, "Too Many Request Header Fields", request)
+		}
+
+		key, value, ok := parseHeaderLine(line)
+		if !ok {
+			return request, NewHttpError(400, "Malformed header", request)
+		}
+
+		// differing lengths make the message framing ambiguous
+		if strings.EqualFold(key, "Content-Length") {
+			if previous := request.Headers.Get(key); previous != "" && previous != value {
+				return request, NewHttpError(400, "Conflicting Content-Length", request)
+			}
+
+			request.Headers.Set(key, value)
+			continue
+		}
+
+		request.Headers.Add(key, value)
 	}
 
+	c.SetReadDeadline(c.bodyDeadline)
+
 	// check if cookie exists in Headers
-	if request.Headers["Cookie"] != "" {
-		request.Cookie = parseCookie(request.Headers["Cookie"])
+	if cookie := request.Headers.Values("Cookie"); len(cookie) != 0 {
+		request.Cookie = parseCookie(strings.Join(cookie, "; "))
+	}
+
+	// parse path and args
+	err = parseTarget(target, &request)
+	if err != nil {
+		return request, err
+	}
+
+	contentLength := request.Headers.Get("Content-Length")
+	transferEncoding := strings.Join(request.Headers.Values("Transfer-Encoding"), ", ")
+
+	// a message with both framing headers is ambiguous and can be used to smuggle requests
+	if contentLength != "" && transferEncoding != "" {
+		return request, NewHttpError(400, "Content-Length and Transfer-Encoding are both present", request)
+	}
+
+	request.body = &body{
+		reader:  eofReader{},
+		request: request,
+		limit:   int64(limitOf(option.MaxBodyBytes)),
+	}
+
+	if transferEncoding != "" {
+		if !strings.EqualFold(transferEncoding, "chunked") {
+			return request, NewHttpError(501, "Unsupported Transfer-Encoding", request)
+		}
+
+		request.body.reader = &chunkedReader{
+			reader:  reader,
+			request: request,
+			option:  option,
+		}
+	}
+
+	// the body is exactly Content-Length bytes
+	if contentLength != "" {
+		// only digits are allowed, ParseInt would also accept a sign
+		if strings.TrimLeft(contentLength, "0123456789") != "" {
+			return request, NewHttpError(400, "Invalid Content-Length", request)
+		}
+
+		length, err := strconv.ParseInt(contentLength, 10, 64)
+		if err != nil || length < 0 {
+			return request, NewHttpError(400, "Invalid Content-Length", request)
+		}
+
+		if length > int64(limitOf(option.MaxBodyBytes)) {
+			return request, NewHttpError(413, "Content Too Large", request)
+		}
+
+		request.body.reader = &lengthReader{
+			reader:    reader,
+			remaining: length,
+		}
+	}
+
+	// the body is read by the handler, a client waiting for 100 Continue is told to send it
+	if request.Version == "HTTP/1.1" && hasToken(request.Headers.Get("Expect"), "100-continue") {
+		request.body.onRead = func() {
+			c.Write(append(appendStatusLine(nil, request.Version, 100), "\r\n"...))
+		}
+	}
+
+	if _, ok := request.body.reader.(eofReader); ok {
+		request.body.setEOF()
+	}
+
+	return request, nil
+}
+
+// eofReader is the reader of an empty body
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+// parseRequestLine parses "method SP request-target SP HTTP-version" into the request and returns
+// the request target in origin-form
+func parseRequestLine(line string, request *Request) (string, error) {
+	requestLine := strings.Split(line, " ")
+	if len(requestLine) != 3 || !isToken(requestLine[0]) {
+		return "", NewHttpError(400, "Malformed request line", *request)
+	}
+
+	request.Method = strings.ToUpper(requestLine[0])
+
+	major, _, ok := parseVersion(requestLine[2])
+	if !ok {
+		return "", NewHttpError(400, "Malformed HTTP version", *request)
+	}
+
+	if major != 1 {
+		return "", NewHttpError(505, "HTTP Version Not Supported", *request)
+	}
+
+	request.Version = requestLine[2]
+
+	target := requestLine[1]
+	for i := 0; i < len(target); i++ {
+		if target[i] <= ' ' || target[i] == 0x7F {
+			return "", NewHttpError(400, "Malformed request target", *request)
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(target, "/"):
+	case target == "*" && request.Method == "OPTIONS":
+	case strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://"):
+		// absolute-form, the authority is not used for routing
+		target = target[strings.Index(target, "//")+2:]
+		if i := strings.IndexAny(target, "/?"); i != -1 {
+			target = target[i:]
+		} else {
+			target = ""
+		}
+
+		if !strings.HasPrefix(target, "/") {
+			target = "/" + target
+		}
+	default:
+		return "", NewHttpError(400, "Malformed request target", *request)
+	}
+
+	return target, nil
+}
+
+// parseVersion parses "HTTP/major.minor"
+func parseVersion(version string) (major, minor int, ok bool) {
+	if len(version) != 8 || !strings.HasPrefix(version, "HTTP/") || version[6] != '.' {
+		return 0, 0, false
+	}
+
+	if !isDigit(version[5]) || !isDigit(version[7]) {
+		return 0, 0, false
+	}
+
+	return int(version[5] - '0'), int(version[7] - '0'), true
+}
+
+// parseHeaderLine parses "field-name: OWS field-value OWS", a line starting with whitespace is an
+// obsolete line folding and is rejected
+func parseHeaderLine(line string) (key, value string, ok bool) {
+	i := strings.IndexByte(line, ':')
+	if i == -1 {
+		return "", "", false
+	}
+
+	// no whitespace is allowed between the field name and the colon
+	key = line[:i]
+	if !isToken(key) {
+		return "", "", false
+	}
+
+	value = strings.Trim(line[i+1:], " \t")
+	for j := 0; j < len(value); j++ {
+		if (value[j] < ' ' && value[j] != '\t') || value[j] == 0x7F {
+			return "", "", false
+		}
+	}
+
+	return key, value, true
+}
+
+// isToken reports whether s is a non empty token (RFC 7230 section 3.2.6)
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isTokenChar(c byte) bool {
+	if isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) != -1
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// readLine reads a single line terminated by CRLF and strips the line ending, it returns
+// [errLineTooLong] when the line is longer than limit bytes and [errMalformedLine] for a bare LF
+func readLine(reader *bufio.Reader, limit int) (string, error) {
+	var line []byte
+
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk)-2 > limit {
+			return "", errLineTooLong
+		}
+
+		line = append(line, chunk...)
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		if len(line) < 2 || line[len(line)-2] != '\r' {
+			return "", errMalformedLine
+		}
+
+		return string(line[:len(line)-2]), nil
 	}
+}
 
-	// parse args
-	request.Path, request.Args = parseArgs(requestLine[1])
+// limitOf returns the configured limit, a negative limit means no limit
+func limitOf(limit int) int {
+	if limit < 0 {
+		return math.MaxInt
+	}
 
-	return request, err
+	return limit
 }
 
 func parseCookie(cookie string) map[string]string {
 	cookieMap := make(map[string]string)
-	cookies := strings.Split(cookie, "; ")
 
-	for _, c := range cookies {
-		cookie := strings.Split(c, "=")
-		cookieMap[cookie[0]] = cookie[1]
+	// the first cookie wins when a name is repeated, it has the most specific path
+	for _, c := range parseCookies(cookie) {
+		if _, ok := cookieMap[c.Name]; !ok {
+			cookieMap[c.Name] = c.Value
+		}
 	}
 
 	return cookieMap
 }
 
-func headerString(headers map[string]string) string {
-	var headerString string
-	for key, value := range headers {
-		headerString += key + ": " + value + "\r\n"
+// parseCookies parses a Cookie header (RFC 6265 section 5.4). Pairs are separated by ";" with
+// optional whitespace, the value is everything after the first "=" without the surrounding
+// quotes, a pair without "=" has an empty value and pairs with an invalid name are skipped
+func parseCookies(cookie string) []*Cookie {
+	cookies := make([]*Cookie, 0)
+
+	for _, pair := range strings.Split(cookie, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, value, _ := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !isToken(name) {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
 	}
 
-	return headerString
+	return cookies
+}
+
+// maxPooledHead is the largest head buffer kept in the pool, a big text body makes it grow
+const maxPooledHead = 64 << 10
+
+// headPool holds the buffers the status line, the headers and text bodies are serialized into
+var headPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 4096)
+		return &b
+	},
 }
 
-func writeResponse(response *Response, conn net.Conn) {
+// writerPool holds the writers streamed bodies are written with
+var writerPool = sync.Pool{
+	New: func() any {
+		return bufio.NewWriterSize(nil, 4096)
+	},
+}
+
+// appendHeader appends the header fields sorted by name, the values of a field keep their order.
+// Fields with an invalid name are left out and CR or LF in a value become spaces, so a value can't
+// add a header or end the head
+func appendHeader(b []byte, headers Header) []byte {
+	// most responses have a few headers, the keys stay on the stack
+	var buf [32]string
+	keys := buf[:0]
+	for key := range headers {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		if !isToken(key) {
+			continue
+		}
+
+		for _, value := range headers[key] {
+			b = append(b, key...)
+			b = append(b, ": "...)
+
+			if !strings.ContainsAny(value, "\r\n") {
+				b = append(b, value...)
+			} else {
+				for i := 0; i < len(value); i++ {
+					if value[i] == '\r' || value[i] == '\n' {
+						b = append(b, ' ')
+					} else {
+						b = append(b, value[i])
+					}
+				}
+			}
+
+			b = append(b, "\r\n"...)
+		}
+	}
+
+	return b
+}
+
+// writeResponse writes the response for the request to the connection, the body is omitted for
+// HEAD requests but Content-Length still describes it. Responses to 1xx, 204 and 304 have neither.
+// A streamed body without Content-Length is sent chunked, or until the connection is closed for
+// HTTP/1.0 clients. An error means the body wasn't fully written and the connection can't be
+// reused.
+//
+// The head and the body are written with a single write when possible, conn must be the
+// underlying connection for [net.Buffers] to use writev
+func writeResponse(response *Response, conn net.Conn, request Request, option *Option) error {
 	if response == nil {
 		response = NewResponse()
 	}
 
 	if response.Headers == nil {
-		response.Headers = make(map[string]string)
+		response.Headers = make(Header)
 	}
 
-	// check if header has a content-type
-	if _, ok := response.Headers["Content-Type"]; !ok {
-		response.Headers["Content-Type"] = "text/plain"
+	if closer, ok := response.Reader.(io.Closer); ok {
+		defer closer.Close()
 	}
 
-	// add content length to Headers
-	response.Headers["Content-Length"] = strconv.Itoa(len(response.Body))
-
 	// check if code is 0
 	if response.Code == 0 {
 		response.Code = 200
 	}
 
-	conn.Write([]byte(
-		"HTTP/1.1 " + strconv.Itoa(response.Code) + "\r\n" +
-			headerString(response.Headers) +
-			"\r\n" +
-			response.Body,
-	))
+	if !response.Headers.Has("Date") {
+		response.Headers.Set("Date", httpDate())
+	}
+
+	if option.ServerName != "" && !response.Headers.Has("Server") {
+		response.Headers.Set("Server", option.ServerName)
+	}
+
+	head := headPool.Get().(*[]byte)
+	defer func() {
+		if cap(*head) <= maxPooledHead {
+			*head = (*head)[:0]
+			headPool.Put(head)
+		}
+	}()
+
+	if !bodyAllowed(response.Code) {
+		response.Headers.Del("Content-Length")
+		response.Headers.Del("Transfer-Encoding")
+
+		*head = appendStatusLine(*head, request.Version, response.Code)
+		*head = appendHeader(*head, response.Headers)
+		*head = append(*head, "\r\n"...)
+
+		_, err := conn.Write(*head)
+
+		return err
+	}
+
+	// check if header has a content-type
+	if !response.Headers.Has("Content-Type") {
+		response.Headers.Set("Content-Type", "text/plain")
+	}
+
+	if response.Reader == nil {
+		// Bytes is used as is, Body is only used without it
+		length := len(response.Body)
+		if response.Bytes != nil {
+			length = len(response.Bytes)
+		}
+
+		// add content length to Headers
+		response.Headers.Set("Content-Length", strconv.Itoa(length))
+
+		*head = appendStatusLine(*head, request.Version, response.Code)
+		*head = appendHeader(*head, response.Headers)
+		*head = append(*head, "\r\n"...)
+
+		if request.Method == "HEAD" {
+			_, err := conn.Write(*head)
+			return err
+		}
+
+		// a text body is copied after the head, bytes are written from where they are
+		if response.Bytes == nil {
+			*head = append(*head, response.Body...)
+
+			_, err := conn.Write(*head)
+
+			return err
+		}
+
+		buffers := net.Buffers{*head, response.Bytes}
+		_, err := buffers.WriteTo(conn)
+
+		return err
+	}
+
+	length := int64(-1)
+	if contentLength := response.Headers.Get("Content-Length"); contentLength != "" {
+		var err error
+		length, err = strconv.ParseInt(contentLength, 10, 64)
+		if err != nil || length < 0 {
+			length = -1
+			response.Headers.Del("Content-Length")
+		}
+	}
+
+	chunked := length < 0 && request.Version != "HTTP/1.0"
+	if chunked {
+		response.Headers.Set("Transfer-Encoding", "chunked")
+
+		// the trailers are announced before the body, their values can be set while it's read
+		if len(response.Trailer) != 0 && !response.Headers.Has("Trailer") {
+			keys := make([]string, 0, len(response.Trailer))
+			for key := range response.Trailer {
+				keys = append(keys, key)
+			}
+
+			slices.Sort(keys)
+			response.Headers.Set("Trailer", strings.Join(keys, ", "))
+		}
+	}
+
+	writer := writerPool.Get().(*bufio.Writer)
+	writer.Reset(conn)
+	defer func() {
+		writer.Reset(nil)
+		writerPool.Put(writer)
+	}()
+
+	*head = appendStatusLine(*head, request.Version, response.Code)
+	*head = appendHeader(*head, response.Headers)
+	*head = append(*head, "\r\n"...)
+
+	// the head goes out with the first part of the body
+	_, err := writer.Write(*head)
+	if err != nil {
+		return err
+	}
+
+	if request.Method == "HEAD" {
+		return writer.Flush()
+	}
+
+	switch {
+	case chunked:
+		_, err = io.Copy(&chunkedWriter{writer}, response.Reader)
+		if err != nil {
+			return err
+		}
+
+		*head = append((*head)[:0], "0\r\n"...)
+		*head = appendHeader(*head, response.Trailer)
+		*head = append(*head, "\r\n"...)
+
+		_, err = writer.Write(*head)
+	case length >= 0:
+		_, err = io.CopyN(&flushWriter{writer}, response.Reader, length)
+	default:
+		_, err = io.Copy(&flushWriter{writer}, response.Reader)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return writer.Flush()
 }
 
 func parsePath(uri string) (method, path string) {
@@ -110,25 +610,74 @@ func parsePath(uri string) (method, path string) {
 	return method, s[1]
 }
 
-func parseArgs(uri string) (string, map[string]string) {
-	s := strings.Split(uri, "?")
-	result := make(map[string]string)
+// parseMediaType parses a header value like `form-data; name="field"` into the lower case value
+// and its parameters, parameter names are lower case and quoted values are unquoted
+func parseMediaType(value string) (string, map[string]string) {
+	params := make(map[string]string)
 
-	if len(s) == 1 {
-		return s[0], result
-	}
+	mediaType, rest, _ := strings.Cut(value, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
 
-	args := strings.Split(s[1], "&")
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " \t;")
 
-	for _, args := range args {
-		arg := strings.Split(args, "=")
-		if len(arg) == 1 {
-			result[arg[0]] = ""
-			continue
+		name, after, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+
+		name = strings.ToLower(strings.TrimSpace(name))
+		after = strings.TrimLeft(after, " \t")
+
+		var param string
+		if strings.HasPrefix(after, `"`) {
+			// quoted string, a backslash escapes the next character
+			var b strings.Builder
+			i := 1
+			for ; i < len(after); i++ {
+				if after[i] == '\\' && i+1 < len(after) {
+					i++
+				} else if after[i] == '"' {
+					i++
+					break
+				}
+
+				b.WriteByte(after[i])
+			}
+
+			param = b.String()
+			_, rest, _ = strings.Cut(after[i:], ";")
+		} else {
+			param, rest, _ = strings.Cut(after, ";")
+			param = strings.TrimSpace(param)
 		}
 
-		result[arg[0]] = arg[1]
+		if name != "" {
+			params[name] = param
+		}
+	}
+
+	return mediaType, params
+}
+
+// parseTarget splits the request target into the raw and decoded path and the query
+func parseTarget(target string, request *Request) error {
+	request.RawPath, request.RawQuery, _ = strings.Cut(target, "?")
+
+	path, err := unescape(request.RawPath, false)
+	if err != nil {
+		return NewHttpError(400, "Invalid escape in path", *request)
+	}
+
+	request.Path = path
+
+	// a malformed pair doesn't fail the request, it's just skipped
+	request.query, _ = parseQuery(request.RawQuery)
+
+	request.Args = make(map[string]string, len(request.query))
+	for key, values := range request.query {
+		request.Args[key] = values[0]
 	}
 
-	return s[0], result
+	return nil
 }
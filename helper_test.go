@@ -0,0 +1,223 @@
+package hfs
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+)
+
+var testOption = Option{
+	MaxHeaderBytes: DEFAULT_MAX_HEADER_BYTES,
+	MaxHeaderCount: DEFAULT_MAX_HEADER_COUNT,
+	MaxURILength:   DEFAULT_MAX_URI_LENGTH,
+	MaxBodyBytes:   DEFAULT_MAX_BODY_BYTES,
+}
+
+// parseRaw parses a request sent in the given writes and reads its body
+func parseRaw(t *testing.T, writes ...string) (Request, string, error) {
+	t.Helper()
+
+	request, _, err := parseRawConn(t, &testOption, writes...)
+	if err != nil {
+		return request, "", err
+	}
+
+	body, err := request.ReadBody()
+
+	return request, string(body), err
+}
+
+// parseRawConn parses a request sent in the given writes without reading its body, the conn
+// reads what was sent after it
+func parseRawConn(t *testing.T, option *Option, writes ...string) (Request, *conn, error) {
+	t.Helper()
+
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	// every write of a pipe is a separate read on the other end
+	go func() {
+		for _, w := range writes {
+			if _, err := client.Write([]byte(w)); err != nil {
+				return
+			}
+		}
+	}()
+
+	c := newConn(context.Background(), server)
+
+	request, err := parseRequest(c, option)
+
+	return request, c, err
+}
+
+func errorCode(err error) int {
+	if httpError, ok := err.(*HttpError); ok {
+		return httpError.Code
+	}
+
+	return 0
+}
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		path   string
+		body   string
+		header [2]string
+	}{
+		{
+			name:   "split request line and headers",
+			writes: []string{"GE", "T /a?b=c HT", "TP/1.1\r", "\nHost: x\r\n", "\r", "\n"},
+			path:   "/a",
+			header: [2]string{"Host", "x"},
+		},
+		{
+			name:   "content length body",
+			writes: []string{"POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhe", "llo"},
+			path:   "/",
+			body:   "hello",
+		},
+		{
+			name:   "repeated equal content length",
+			writes: []string{"POST / HTTP/1.1\r\nContent-Length: 2\r\nContent-Length: 2\r\n\r\nhi"},
+			path:   "/",
+			body:   "hi",
+		},
+		{
+			name: "chunked with trailers",
+			writes: []string{
+				"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n",
+				"3;ext=1\r\nabc\r\n", "2\r\nde\r\n", "0\r\nX-Trailer: yes\r\n\r\n",
+			},
+			path:   "/",
+			body:   "abcde",
+			header: [2]string{"X-Trailer", "yes"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, body, err := parseRaw(t, test.writes...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if request.Path != test.path {
+				t.Errorf("path = %q, want %q", request.Path, test.path)
+			}
+
+			if body != test.body {
+				t.Errorf("body = %q, want %q", body, test.body)
+			}
+
+			if test.header[0] != "" && request.Headers.Get(test.header[0]) != test.header[1] {
+				t.Errorf("%s = %q, want %q", test.header[0], request.Headers.Get(test.header[0]), test.header[1])
+			}
+		})
+	}
+}
+
+func TestParseRequestErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+		code    int
+	}{
+		{"content length and transfer encoding", "POST / HTTP/1.1\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", 400},
+		{"conflicting content length", "POST / HTTP/1.1\r\nContent-Length: 3\r\nContent-Length: 4\r\n\r\nabcd", 400},
+		{"signed content length", "POST / HTTP/1.1\r\nContent-Length: +3\r\n\r\nabc", 400},
+		{"negative content length", "POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n", 400},
+		{"unsupported transfer encoding", "POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n", 501},
+		{"signed chunk size", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n+3\r\nabc\r\n0\r\n\r\n", 400},
+		{"prefixed chunk size", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0x3\r\nabc\r\n0\r\n\r\n", 400},
+		{"missing chunk crlf", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabcd\r\n0\r\n\r\n", 400},
+		{"bare lf request line", "GET / HTTP/1.1\nHost: x\r\n\r\n", 400},
+		{"bare lf header", "GET / HTTP/1.1\r\nHost: x\n\r\n", 400},
+		{"obs-fold", "GET / HTTP/1.1\r\nX-A: a\r\n b\r\n\r\n", 400},
+		{"space before colon", "GET / HTTP/1.1\r\nHost : x\r\n\r\n", 400},
+		{"invalid method", "G(T / HTTP/1.1\r\n\r\n", 400},
+		{"unsupported version", "GET / HTTP/2.0\r\n\r\n", 505},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := parseRaw(t, test.request)
+			if code := errorCode(err); code != test.code {
+				t.Errorf("error = %v, want code %d", err, test.code)
+			}
+		})
+	}
+}
+
+func TestAppendHeader(t *testing.T) {
+	headers := Header{
+		"X-B":          {"1"},
+		"X-A":          {"2", "3"},
+		"X-Split":      {"a\r\nX-Injected: yes"},
+		"X-Bad\r\nKey": {"b"},
+		"":             {"c"},
+	}
+
+	want := "X-A: 2\r\nX-A: 3\r\nX-B: 1\r\nX-Split: a  X-Injected: yes\r\n"
+	if got := string(appendHeader(nil, headers)); got != want {
+		t.Errorf("appendHeader = %q, want %q", got, want)
+	}
+}
+
+// discardConn counts the writes of a response and drops them
+type discardConn struct {
+	net.Conn
+	writes int
+}
+
+func (c *discardConn) Write(p []byte) (int, error) {
+	c.writes++
+	return len(p), nil
+}
+
+func BenchmarkWriteResponse(b *testing.B) {
+	payload := bytes.Repeat([]byte("a"), 4096)
+	stream := bytes.NewReader(payload)
+	request := Request{Method: "GET", Version: "HTTP/1.1"}
+
+	tests := []struct {
+		name     string
+		response *Response
+		// reset prepares the response for the next write
+		reset func()
+	}{
+		{"text", NewTextResponse("Hello, World"), nil},
+		{"bytes", NewBytesResponse("application/octet-stream", payload), nil},
+		{"stream", NewStreamResponse("application/octet-stream", stream), func() { stream.Reset(payload) }},
+	}
+
+	for _, test := range tests {
+		b.Run(test.name, func(b *testing.B) {
+			test.response.Headers.Set("Cache-Control", "no-cache")
+			test.response.AddCookie(&Cookie{Name: "session", Value: "abc", Path: "/"})
+
+			conn := &discardConn{}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if test.reset != nil {
+					test.reset()
+				}
+
+				if err := writeResponse(test.response, conn, request, &testOption); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ReportMetric(float64(conn.writes)/float64(b.N), "writes/op")
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package hfs
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// JSON creates a response with the value encoded as JSON, a value that can't be encoded is
+// answered by the error handler instead
+func JSON(code int, v any) *Response {
+	output, err := json.Marshal(v)
+	if err != nil {
+		return NewErrorResponse(NewHandlingError("Error while encoding JSON: " + err.Error()))
+	}
+
+	return &Response{
+		Code: code,
+		Headers: Header{
+			"Content-Type": {"application/json"},
+		},
+		Bytes: output,
+	}
+}
+
+// BindJSON decodes the JSON body into v, a body that isn't JSON is answered with 415 and a
+// malformed one with 400. The body is limited by MaxBodyBytes
+func (r *Request) BindJSON(v any) error {
+	return r.bindJSON(v, false)
+}
+
+// BindStrictJSON is like [Request.BindJSON] but fields that aren't in v are rejected with 400
+func (r *Request) BindStrictJSON(v any) error {
+	return r.bindJSON(v, true)
+}
+
+func (r *Request) bindJSON(v any, strict bool) error {
+	mediaType, _ := parseMediaType(r.Headers.Get("Content-Type"))
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return NewHttpError(415, "Content-Type is not application/json", *r)
+	}
+
+	decoder := json.NewDecoder(r.BodyReader())
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+
+	err := decoder.Decode(v)
+	if err == io.EOF {
+		return NewHttpError(400, "Empty JSON body", *r)
+	}
+
+	if err != nil {
+		return badBodyError(r, "Invalid JSON body", err)
+	}
+
+	// the body must hold a single value
+	_, err = decoder.Token()
+	if err != io.EOF {
+		if err != nil {
+			return badBodyError(r, "Invalid JSON body", err)
+		}
+
+		return NewHttpError(400, "Invalid JSON body: unexpected data after the value", *r)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package hfs
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type jsonUser struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func jsonRequest(t *testing.T, contentType, body string) Request {
+	t.Helper()
+
+	option := testOption
+	option.MaxBodyBytes = 64
+
+	header := "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n"
+	if contentType != "" {
+		header += "Content-Type: " + contentType + "\r\n"
+	}
+
+	// chunked so the limit is hit while the body is decoded
+	chunked := ""
+	if body != "" {
+		chunked = strconv.FormatInt(int64(len(body)), 16) + "\r\n" + body + "\r\n"
+	}
+
+	request, _, err := parseRawConn(t, &option, header+"\r\n"+chunked+"0\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return request
+}
+
+func TestBindJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		strict      bool
+		want        jsonUser
+		code        int
+	}{
+		{"valid", "application/json", `{"name":"a","age":3}`, false, jsonUser{"a", 3}, 0},
+		{"charset", "application/json; charset=utf-8", `{"name":"a"}`, false, jsonUser{Name: "a"}, 0},
+		{"json suffix", "application/problem+json", `{"age":1}`, false, jsonUser{Age: 1}, 0},
+		{"trailing whitespace", "application/json", "{\"name\":\"a\"}\r\n", false, jsonUser{Name: "a"}, 0},
+		{"unknown field", "application/json", `{"name":"a","admin":true}`, false, jsonUser{Name: "a"}, 0},
+		{"unknown field strict", "application/json", `{"name":"a","admin":true}`, true, jsonUser{}, 400},
+		{"missing content type", "", `{"name":"a"}`, false, jsonUser{}, 415},
+		{"wrong content type", "text/plain", `{"name":"a"}`, false, jsonUser{}, 415},
+		{"empty body", "application/json", "", false, jsonUser{}, 400},
+		{"malformed", "application/json", `{"name":`, false, jsonUser{}, 400},
+		{"wrong type", "application/json", `{"age":"three"}`, false, jsonUser{}, 400},
+		{"trailing data", "application/json", `{"name":"a"} {"name":"b"}`, false, jsonUser{}, 400},
+		{"trailing garbage", "application/json", `{"name":"a"}}`, false, jsonUser{}, 400},
+		{"over the limit", "application/json", `{"name":"` + strings.Repeat("a", 100) + `"}`, false, jsonUser{}, 413},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := jsonRequest(t, test.contentType, test.body)
+
+			var user jsonUser
+			var err error
+			if test.strict {
+				err = request.BindStrictJSON(&user)
+			} else {
+				err = request.BindJSON(&user)
+			}
+
+			if code := errorCode(err); code != test.code {
+				t.Fatalf("error = %v, want code %d", err, test.code)
+			}
+
+			if test.code == 0 && user != test.want {
+				t.Errorf("user = %+v, want %+v", user, test.want)
+			}
+		})
+	}
+}
+
+func TestJSONResponse(t *testing.T) {
+	response := JSON(201, jsonUser{Name: "a", Age: 3})
+	if response.Code != 201 || response.Headers.Get("Content-Type") != "application/json" {
+		t.Errorf("response = %d %q, want 201 application/json", response.Code, response.Headers.Get("Content-Type"))
+	}
+
+	if string(response.Bytes) != `{"name":"a","age":3}` {
+		t.Errorf("body = %s", response.Bytes)
+	}
+}
+
+func TestJSONResponseError(t *testing.T) {
+	handled := make(chan error, 1)
+	option := Option{
+		ErrHandler: func(request Request, err error) *Response {
+			handled <- err
+
+			response := NewTextResponse("handled")
+			response.SetCode(500)
+
+			return response
+		},
+	}
+
+	_, address := startServer(t, option, func(s *Server) {
+		s.Handle("/", func(Request) *Response {
+			// channels can't be encoded
+			return JSON(200, make(chan int))
+		})
+	})
+
+	c, reader := dial(t, address)
+	c.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+
+	response, body := readResponse(t, reader, "GET")
+	if response.StatusCode != 500 || body != "handled" {
+		t.Errorf("response = %d %q, want the error handler response", response.StatusCode, body)
+	}
+
+	if err := <-handled; err == nil || !strings.Contains(err.Error(), "JSON") {
+		t.Errorf("error handler got %v, want the encoding error", err)
+	}
+}
@@ -0,0 +1,48 @@
+package hfs
+
+// Middleware wraps a handler, it decides whether and with which request the next handler is
+// called and can change the response it returns
+//
+//	func auth(next hfs.ResponseHandler) hfs.ResponseHandler {
+//		return func(req hfs.Request) *hfs.Response {
+//			if req.GetHeader("Authorization") == "" {
+//				return &hfs.Response{Code: 401}
+//			}
+//
+//			return next(req)
+//		}
+//	}
+type Middleware func(next ResponseHandler) ResponseHandler
+
+// Middleware adapts the middleware handler into a [Middleware] that calls it before the next
+// handler, it always continues the chain with the original request
+func (m MiddlewareHandler) Middleware() Middleware {
+	return func(next ResponseHandler) ResponseHandler {
+		return func(request Request) *Response {
+			m(request)
+
+			return next(request)
+		}
+	}
+}
+
+// Chain wraps the handler with the middleware, the first middleware is the outermost one
+//
+//	server.Handle("GET /admin", hfs.Chain(handler, auth, timing))
+func Chain(handler ResponseHandler, middleware ...Middleware) ResponseHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
+
+// adaptMiddleware adapts the middleware handlers into [Middleware]
+func adaptMiddleware(middleware []MiddlewareHandler) []Middleware {
+	result := make([]Middleware, 0, len(middleware))
+	for _, m := range middleware {
+		result = append(result, m.Middleware())
+	}
+
+	return result
+}
@@ -0,0 +1,236 @@
+package hfs
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// maxPartHeaderBytes limits the headers of a multipart part
+const maxPartHeaderBytes = 16 * 1024
+
+// MultipartReader reads the parts of a multipart body one by one without buffering it
+//
+//	reader, err := req.MultipartReader()
+//	for {
+//		part, err := reader.NextPart()
+//		if err == io.EOF {
+//			break
+//		}
+//
+//		io.Copy(dst, part)
+//	}
+type MultipartReader struct {
+	reader *bufio.Reader
+	// dashBoundary is "--boundary", delimiter is the CRLF that ends the part content followed
+	// by the dash boundary
+	dashBoundary string
+	delimiter    []byte
+	current      *Part
+	started      bool
+	done         bool
+}
+
+// Part is a single part of a multipart body, reading it returns its content
+type Part struct {
+	Header Header
+
+	reader *MultipartReader
+	eof    bool
+	// params of the Content-Disposition header
+	disposition map[string]string
+}
+
+// NewMultipartReader creates a reader for a multipart body with the boundary
+func NewMultipartReader(reader io.Reader, boundary string) *MultipartReader {
+	return &MultipartReader{
+		reader:       bufio.NewReaderSize(reader, 4096),
+		dashBoundary: "--" + boundary,
+		delimiter:    []byte("\r\n--" + boundary),
+	}
+}
+
+// NextPart returns the next part, the rest of the previous part is discarded. It returns io.EOF
+// after the last part
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	if mr.current != nil {
+		_, err := io.Copy(io.Discard, mr.current)
+		if err != nil {
+			return nil, err
+		}
+
+		mr.current = nil
+	}
+
+	if mr.done {
+		return nil, io.EOF
+	}
+
+	if !mr.started {
+		// skip the preamble until the first boundary
+		for {
+			line, err := mr.readLine()
+			if err != nil {
+				return nil, err
+			}
+
+			line = strings.TrimRight(line, " \t")
+			if line == mr.dashBoundary+"--" {
+				mr.done = true
+				return nil, io.EOF
+			}
+
+			if line == mr.dashBoundary {
+				break
+			}
+		}
+
+		mr.started = true
+	} else {
+		// the previous part stopped right before the delimiter
+		_, err := mr.reader.Discard(len(mr.delimiter))
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+
+		// "--" after the boundary closes the body
+		if next, _ := mr.reader.Peek(2); string(next) == "--" {
+			mr.done = true
+			return nil, io.EOF
+		}
+
+		line, err := mr.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.Trim(line, " \t") != "" {
+			return nil, NewServerError("Malformed multipart boundary")
+		}
+	}
+
+	part := &Part{
+		Header: make(Header),
+		reader: mr,
+	}
+
+	headerBytes := maxPartHeaderBytes
+	for {
+		line, err := readLine(mr.reader, headerBytes)
+		if err != nil {
+			return nil, multipartError(err)
+		}
+
+		if line == "" {
+			break
+		}
+
+		headerBytes -= len(line)
+
+		key, value, ok := parseHeaderLine(line)
+		if !ok {
+			return nil, NewServerError("Malformed multipart header")
+		}
+
+		part.Header.Add(key, value)
+	}
+
+	_, part.disposition = parseMediaType(part.Header.Get("Content-Disposition"))
+	mr.current = part
+
+	return part, nil
+}
+
+// readLine reads a boundary line, the closing boundary may be the end of the body without CRLF
+func (mr *MultipartReader) readLine() (string, error) {
+	line, err := readLine(mr.reader, maxPartHeaderBytes)
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		return "", multipartError(err)
+	}
+
+	return line, nil
+}
+
+// FormName returns the name parameter of the Content-Disposition header
+func (p *Part) FormName() string {
+	return p.disposition["name"]
+}
+
+// FileName returns the filename parameter of the Content-Disposition header without directories
+func (p *Part) FileName() string {
+	filename := p.disposition["filename"]
+	if i := strings.LastIndexAny(filename, `/\`); i != -1 {
+		filename = filename[i+1:]
+	}
+
+	return filename
+}
+
+// Read reads the content of the part, it stops at the boundary of the next part
+func (p *Part) Read(b []byte) (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+
+	mr := p.reader
+	delimiter := mr.delimiter
+
+	for {
+		// make sure a whole delimiter can be seen
+		_, err := mr.reader.Peek(len(delimiter))
+		buf, _ := mr.reader.Peek(mr.reader.Buffered())
+
+		if i := bytes.Index(buf, delimiter); i != -1 {
+			n := copy(b, buf[:i])
+			mr.reader.Discard(n)
+
+			if n == i {
+				p.eof = true
+				if n == 0 {
+					return 0, io.EOF
+				}
+			}
+
+			return n, nil
+		}
+
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+
+		// the end of the buffer may be the start of a delimiter
+		safe := len(buf) - len(delimiter) + 1
+		if safe > 0 {
+			n := copy(b, buf[:safe])
+			mr.reader.Discard(n)
+
+			return n, nil
+		}
+	}
+}
+
+// multipartError maps the line errors to multipart errors
+func multipartError(err error) error {
+	switch err {
+	case errLineTooLong:
+		return NewServerError("Multipart header too large")
+	case errMalformedLine:
+		return NewServerError("Malformed multipart line")
+	default:
+		return unexpectedEOF(err)
+	}
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
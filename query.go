@@ -0,0 +1,103 @@
+package hfs
+
+import "strings"
+
+// Query holds decoded query or form values, a key can have multiple values
+//
+//	// ?tag=a&tag=b
+//	req.Query().Get("tag") // "a"
+//	req.Query().All("tag") // ["a", "b"]
+type Query map[string][]string
+
+// Get returns the first value of the key, or "" if it's not set
+func (q Query) Get(key string) string {
+	values := q[key]
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// All returns every value of the key
+func (q Query) All(key string) []string {
+	return q[key]
+}
+
+// Has reports whether the key is set
+func (q Query) Has(key string) bool {
+	_, ok := q[key]
+	return ok
+}
+
+// parseQuery decodes "key=value&key=value", "+" is decoded as a space. Pairs that can't be
+// decoded are skipped and the first error is returned
+func parseQuery(raw string) (Query, error) {
+	query := make(Query)
+	var err error
+
+	for _, pair := range strings.Split(raw, "&") {
+		if pair == "" {
+			continue
+		}
+
+		key, value, _ := strings.Cut(pair, "=")
+
+		key, keyErr := unescape(key, true)
+		value, valueErr := unescape(value, true)
+		if keyErr != nil || valueErr != nil {
+			if err == nil {
+				err = NewServerError("Invalid escape in query: " + pair)
+			}
+
+			continue
+		}
+
+		query[key] = append(query[key], value)
+	}
+
+	return query, err
+}
+
+// unescape decodes percent escapes, plus decodes "+" as a space like in query strings
+func unescape(s string, plus bool) (string, error) {
+	if strings.IndexByte(s, '%') == -1 && (!plus || strings.IndexByte(s, '+') == -1) {
+		return s, nil
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '%':
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				return "", NewServerError("Invalid escape: " + s)
+			}
+
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+		case s[i] == '+' && plus:
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String(), nil
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case isDigit(c):
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
@@ -1,26 +1,127 @@
 package hfs
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"net"
+	"strings"
 )
 
 type Request struct {
+	// Context is cancelled when the client closes the connection, the request is done or the
+	// server is closed, use [Request.WithValue] to attach values for the next handlers
 	Context context.Context
 	Method  string
-	Path    string
-	Version string
-	Body    string
+	// Path is the decoded path, RawPath is the path as sent by the client
+	Path     string
+	RawPath  string
+	RawQuery string
+	Version  string
+	// Args holds the first value of every query parameter, use [Request.Query] for every value
 	Args    map[string]string
-	Headers map[string]string
+	Params  map[string]string
+	Headers Header
 	Cookie  map[string]string
 	Conn    net.Conn
+	// Form holds the form fields of the body once [Request.ParseForm] or
+	// [Request.ParseMultipartForm] is called
+	Form          Query
+	MultipartForm *MultipartForm
+
+	conn  *conn
+	query Query
+	body  *body
 }
 
 func (r *Request) GetHeader(key string) string {
-	return r.Headers[key]
+	return r.Headers.Get(key)
 }
 
 func (r *Request) GetArgs(arg string) string {
 	return r.Args[arg]
 }
+
+// Cookies returns the cookies sent with the request in their order
+func (r *Request) Cookies() []*Cookie {
+	return parseCookies(strings.Join(r.Headers.Values("Cookie"), "; "))
+}
+
+// GetCookie returns the first cookie with the name
+func (r *Request) GetCookie(name string) (*Cookie, bool) {
+	for _, cookie := range r.Cookies() {
+		if cookie.Name == name {
+			return cookie, true
+		}
+	}
+
+	return nil, false
+}
+
+// BodyReader returns the body as it's read from the connection, it can only be read once unless
+// [Request.ReadBody] was called before. Errors are [HttpError]s, e.g. 413 when the body exceeds
+// MaxBodyBytes
+func (r *Request) BodyReader() io.ReadCloser {
+	if r.body == nil {
+		return io.NopCloser(strings.NewReader(""))
+	}
+
+	if r.body.cached {
+		return io.NopCloser(bytes.NewReader(r.body.content))
+	}
+
+	return r.body
+}
+
+// ReadBody reads the whole body, the result is kept so it can be called again. It replaces the
+// former Body field, use string(body) where the string is needed. A failed read returns the
+// [HttpError] to answer, e.g. 413 when the body exceeds MaxBodyBytes, pass it to
+// [NewErrorResponse] to answer it with the error handler
+func (r *Request) ReadBody() ([]byte, error) {
+	if r.body == nil {
+		return nil, nil
+	}
+
+	if r.body.cached {
+		return r.body.content, nil
+	}
+
+	content, err := io.ReadAll(r.body)
+	if err != nil {
+		return nil, err
+	}
+
+	r.body.content = content
+	r.body.cached = true
+
+	return content, nil
+}
+
+// Query returns the decoded query parameters
+func (r *Request) Query() Query {
+	return r.query
+}
+
+// Param returns the value of a path parameter, e.g. "id" for "/users/:id"
+func (r *Request) Param(name string) string {
+	return r.Params[name]
+}
+
+// WithContext returns a copy of the request with the context replaced
+func (r *Request) WithContext(ctx context.Context) Request {
+	request := *r
+	request.Context = ctx
+
+	return request
+}
+
+// WithValue returns a copy of the request whose context carries the value, pass it to the next
+// handler of a [Middleware] so the handler can read it with [Request.Value]
+func (r *Request) WithValue(key, value any) Request {
+	return r.WithContext(context.WithValue(r.Context, key, value))
+}
+
+// Value returns the value for the key from the request context
+func (r *Request) Value(key any) any {
+	return r.Context.Value(key)
+}
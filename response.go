@@ -1,25 +1,39 @@
 package hfs
 
-import "strconv"
+import (
+	"io"
+	"time"
+)
 
 type Response struct {
 	Code int
 	// you need to assign a headers map if you create response from [Response],
-	// please use [NewResponse] instead to avoid nil headers
-	Headers map[string]string
+	// please use [NewResponse] instead to avoid nil headers. A map[string]string literal can be
+	// converted with [NewHeader]
+	Headers Header
 	Body    string
+	// Bytes is the body for binary payloads, it's used instead of Body when it isn't nil
+	Bytes []byte
+	// Reader streams the body instead of Body or Bytes, it's closed after the response if it's an
+	// [io.Closer]. Without a Content-Length header the body is sent chunked
+	Reader io.Reader
+	// Trailer holds the fields sent after a chunked body, the keys must be set before the
+	// response is written but the values can be filled while Reader is read
+	Trailer Header
+	// err is answered by the error handler instead of the response, see [NewErrorResponse]
+	err error
 }
 
 func NewResponse() *Response {
 	return &Response{
 		Code:    200,
-		Headers: make(map[string]string),
+		Headers: make(Header),
 	}
 }
 
 func NewTextResponse(text string) *Response {
-	header := map[string]string{
-		"Content-Type": "text/plain",
+	header := Header{
+		"Content-Type": {"text/plain"},
 	}
 
 	return &Response{
@@ -30,8 +44,8 @@ func NewTextResponse(text string) *Response {
 }
 
 func NewHTMLResponse(html string) *Response {
-	header := map[string]string{
-		"Content-Type": "text/html",
+	header := Header{
+		"Content-Type": {"text/html"},
 	}
 
 	return &Response{
@@ -42,8 +56,8 @@ func NewHTMLResponse(html string) *Response {
 }
 
 func NewJSONResponse(json string) *Response {
-	header := map[string]string{
-		"Content-Type": "application/json",
+	header := Header{
+		"Content-Type": {"application/json"},
 	}
 
 	return &Response{
@@ -53,18 +67,95 @@ func NewJSONResponse(json string) *Response {
 	}
 }
 
+// NewErrorResponse creates a response that is answered by the error handler with the error, e.g.
+// the [HttpError] returned by [Request.ReadBody]
+func NewErrorResponse(err error) *Response {
+	return &Response{
+		Code:    500,
+		Headers: make(Header),
+		err:     err,
+	}
+}
+
+// NewBytesResponse creates a response with a binary body, the bytes are written without a copy
+// so they must not be modified until the response is written
+func NewBytesResponse(contentType string, body []byte) *Response {
+	header := Header{
+		"Content-Type": {contentType},
+	}
+
+	return &Response{
+		Code:    200,
+		Headers: header,
+		Bytes:   body,
+	}
+}
+
+// NewStreamResponse creates a response whose body is read from the reader while it's written,
+// set the Content-Length header if the length is known
+func NewStreamResponse(contentType string, reader io.Reader) *Response {
+	header := Header{
+		"Content-Type": {contentType},
+	}
+
+	return &Response{
+		Code:    200,
+		Headers: header,
+		Reader:  reader,
+	}
+}
+
+// AddHeader sets the header, replacing its values, use Headers.Add to append a value
 func (r *Response) AddHeader(key, value string) {
-	r.Headers[key] = value
+	r.Headers.Set(key, value)
 }
 
+// SetBody sets a text body, it replaces a body set with [Response.SetBytes]
 func (r *Response) SetBody(body string) {
 	r.Body = body
+	r.Bytes = nil
+}
+
+// SetBytes sets a binary body, it replaces a body set with [Response.SetBody]
+func (r *Response) SetBytes(body []byte) {
+	r.Bytes = body
+	r.Body = ""
 }
 
 func (r *Response) SetCode(code int) {
 	r.Code = code
 }
 
+// SetCookie adds a cookie, a maxAge of zero or less deletes it. Use [Response.AddCookie] to set
+// the other attributes
 func (r *Response) SetCookie(key, value, path string, maxAge int) {
-	r.Headers["Set-Cookie"] = key + "=" + value + "; Path=" + path + "; Max-Age=" + strconv.Itoa(maxAge)
+	if maxAge <= 0 {
+		maxAge = -1
+	}
+
+	r.AddCookie(&Cookie{
+		Name:   key,
+		Value:  value,
+		Path:   path,
+		MaxAge: maxAge,
+	})
+}
+
+// AddCookie adds a Set-Cookie header for the cookie, every cookie has its own header. Cookies with
+// an invalid name are ignored
+func (r *Response) AddCookie(cookie *Cookie) {
+	if value := cookie.String(); value != "" {
+		r.Headers.Add("Set-Cookie", value)
+	}
+}
+
+// DeleteCookie tells the client to remove the cookie, the path must match the one the cookie was
+// set with
+func (r *Response) DeleteCookie(name, path string) {
+	r.AddCookie(&Cookie{
+		Name:    name,
+		Path:    path,
+		Expires: time.Unix(0, 0),
+		MaxAge:  -1,
+	})
 }
@@ -0,0 +1,177 @@
+package hfs
+
+import (
+	"sort"
+	"strings"
+)
+
+// router is a prefix tree of path segments, every node keeps the handlers registered for its
+// path keyed by method, "" is the handler for any method. Handlers are stored as indexes of
+// [Server.Handlers] so the route table stays the single source of truth
+type router struct {
+	root *node
+}
+
+type node struct {
+	static   map[string]*node
+	param    *node
+	wildcard *node
+	// name is the parameter name of a param or wildcard node
+	name     string
+	handlers map[string]int
+}
+
+func newRouter() *router {
+	return &router{root: &node{}}
+}
+
+// insert adds the handler index for the method and path pattern.
+//
+// ":name" matches a single path segment, "*name" matches the rest of the path and must be the
+// last segment
+func (r *router) insert(method, pattern string, index int) error {
+	if !strings.HasPrefix(pattern, "/") {
+		return NewServerError("Path must start with '/': " + pattern)
+	}
+
+	n := r.root
+	segments := splitPath(pattern)
+
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*"):
+			name := segment[1:]
+			if name == "" {
+				return NewServerError("Parameter name is required: " + pattern)
+			}
+
+			child := &n.param
+			if segment[0] == '*' {
+				if i != len(segments)-1 {
+					return NewServerError("Wildcard must be the last segment: " + pattern)
+				}
+
+				child = &n.wildcard
+			}
+
+			if *child == nil {
+				*child = &node{name: name}
+			}
+
+			if (*child).name != name {
+				return NewServerError("Conflicting parameter name " + segment + " in " + pattern)
+			}
+
+			n = *child
+		default:
+			if n.static == nil {
+				n.static = make(map[string]*node)
+			}
+
+			child, ok := n.static[segment]
+			if !ok {
+				child = &node{}
+				n.static[segment] = child
+			}
+
+			n = child
+		}
+	}
+
+	if n.handlers == nil {
+		n.handlers = make(map[string]int)
+	}
+
+	if _, ok := n.handlers[method]; ok {
+		return NewServerError("Duplicate path found")
+	}
+
+	n.handlers[method] = index
+
+	return nil
+}
+
+// lookup returns the node registered for the raw path and the decoded values of its parameters,
+// static segments take precedence over parameters and parameters over wildcards. Segments are
+// decoded one by one so an escaped "/" doesn't split a segment
+func (r *router) lookup(rawPath string) (*node, map[string]string) {
+	params := make(map[string]string)
+
+	segments := splitPath(rawPath)
+	for i, segment := range segments {
+		if decoded, err := unescape(segment, false); err == nil {
+			segments[i] = decoded
+		}
+	}
+
+	n := r.root.lookup(segments, params)
+	if n == nil {
+		return nil, nil
+	}
+
+	return n, params
+}
+
+func (n *node) lookup(segments []string, params map[string]string) *node {
+	if len(segments) == 0 {
+		if n.handlers != nil {
+			return n
+		}
+
+		// a wildcard also matches an empty rest of the path
+		if n.wildcard != nil {
+			params[n.wildcard.name] = ""
+			return n.wildcard
+		}
+
+		return nil
+	}
+
+	segment := segments[0]
+
+	if child, ok := n.static[segment]; ok {
+		if found := child.lookup(segments[1:], params); found != nil {
+			return found
+		}
+	}
+
+	if n.param != nil && segment != "" {
+		if found := n.param.lookup(segments[1:], params); found != nil {
+			params[n.param.name] = segment
+			return found
+		}
+	}
+
+	if n.wildcard != nil {
+		params[n.wildcard.name] = strings.Join(segments, "/")
+		return n.wildcard
+	}
+
+	return nil
+}
+
+// allowedMethods returns the methods registered on the node for the Allow header, OPTIONS is
+// always allowed because the server answers it automatically and HEAD is allowed with GET
+func (n *node) allowedMethods() string {
+	methods := []string{"OPTIONS"}
+	for method := range n.handlers {
+		if method != "OPTIONS" {
+			methods = append(methods, method)
+		}
+	}
+
+	if _, ok := n.handlers["GET"]; ok {
+		if _, ok := n.handlers["HEAD"]; !ok {
+			methods = append(methods, "HEAD")
+		}
+	}
+
+	sort.Strings(methods)
+
+	return strings.Join(methods, ", ")
+}
+
+// splitPath splits the path into segments, "/" is a single empty segment
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
@@ -0,0 +1,156 @@
+package hfs
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestRouterLookup(t *testing.T) {
+	r := newRouter()
+	patterns := []string{
+		"/users/new",
+		"/users/:id",
+		"/users/:id/posts",
+		"/files/*path",
+		"/files/static/logo",
+		"/a/:b/c",
+		"/a/*rest",
+	}
+
+	for i, pattern := range patterns {
+		if err := r.insert("GET", pattern, i); err != nil {
+			t.Fatalf("insert %s: %v", pattern, err)
+		}
+	}
+
+	tests := []struct {
+		path    string
+		pattern string
+		params  map[string]string
+	}{
+		{"/users/new", "/users/new", map[string]string{}},
+		{"/users/42", "/users/:id", map[string]string{"id": "42"}},
+		{"/users/42/posts", "/users/:id/posts", map[string]string{"id": "42"}},
+		{"/files/static/logo", "/files/static/logo", map[string]string{}},
+		{"/files/static/logo.png", "/files/*path", map[string]string{"path": "static/logo.png"}},
+		{"/files/a/b/c", "/files/*path", map[string]string{"path": "a/b/c"}},
+		{"/files", "/files/*path", map[string]string{"path": ""}},
+		{"/files/", "/files/*path", map[string]string{"path": ""}},
+		// the param route doesn't match so the lookup backtracks to the wildcard
+		{"/a/b/d", "/a/*rest", map[string]string{"rest": "b/d"}},
+		{"/a/b/c", "/a/:b/c", map[string]string{"b": "b"}},
+		// an escaped slash stays inside its segment
+		{"/users/a%2Fb", "/users/:id", map[string]string{"id": "a/b"}},
+		{"/users/a%2Fb/posts", "/users/:id/posts", map[string]string{"id": "a/b"}},
+		{"/users//posts", "", nil},
+		{"/nothing", "", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			n, params := r.lookup(test.path)
+			if test.pattern == "" {
+				if n != nil {
+					t.Fatalf("matched %s, want no match", patterns[n.handlers["GET"]])
+				}
+
+				return
+			}
+
+			if n == nil {
+				t.Fatalf("no match, want %s", test.pattern)
+			}
+
+			if got := patterns[n.handlers["GET"]]; got != test.pattern {
+				t.Errorf("matched %s, want %s", got, test.pattern)
+			}
+
+			if fmt.Sprint(params) != fmt.Sprint(test.params) {
+				t.Errorf("params = %v, want %v", params, test.params)
+			}
+		})
+	}
+}
+
+func TestRouterInsertErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		pattern  string
+	}{
+		{"conflicting param name", "/users/:id", "/users/:name/posts"},
+		{"conflicting wildcard name", "/files/*path", "/files/*rest"},
+		{"duplicate route", "/users/:id", "/users/:id"},
+		{"wildcard not last", "", "/files/*path/edit"},
+		{"missing param name", "", "/users/:"},
+		{"relative path", "", "users"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newRouter()
+			if test.existing != "" {
+				if err := r.insert("GET", test.existing, 0); err != nil {
+					t.Fatalf("insert %s: %v", test.existing, err)
+				}
+			}
+
+			if err := r.insert("GET", test.pattern, 1); err == nil {
+				t.Errorf("insert %s succeeded, want an error", test.pattern)
+			}
+		})
+	}
+}
+
+var benchmarkSizes = []int{10, 1000, 10000}
+
+func benchmarkPath(i int) string {
+	return "/api/v1/resource" + strconv.Itoa(i) + "/items"
+}
+
+// BenchmarkLookupSlice scans the route table like the server did before the router, the last
+// route is looked up as the worst case
+func BenchmarkLookupSlice(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			handlers := make([]Handler, size)
+			for i := range handlers {
+				handlers[i] = Handler{Path: benchmarkPath(i), Method: "GET"}
+			}
+
+			path := benchmarkPath(size - 1)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				for j := range handlers {
+					if handlers[j].Path == path && handlers[j].Method == "GET" {
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkLookupTree(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			r := newRouter()
+			for i := 0; i < size; i++ {
+				r.insert("GET", benchmarkPath(i), i)
+			}
+
+			path := benchmarkPath(size - 1)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				r.lookup(path)
+			}
+		})
+	}
+}
@@ -1,10 +1,15 @@
 package hfs
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type ResponseHandler func(Request) *Response
@@ -16,6 +21,9 @@ type Handler struct {
 	Method     string
 	Handler    ResponseHandler
 	Middleware []MiddlewareHandler
+
+	// group is the group the handler was registered on, nil for the server
+	group *Group
 }
 
 func (handler *Handler) Use(middleware MiddlewareHandler) *Handler {
@@ -27,11 +35,57 @@ func (handler *Handler) Use(middleware MiddlewareHandler) *Handler {
 type Option struct {
 	ErrHandler       ErrResponseHandler
 	GlobalMiddleware []MiddlewareHandler
+	// Middleware wraps every handler, it runs after GlobalMiddleware
+	Middleware []Middleware
+	// ReadHeaderTimeout is how long reading the request line and headers may take, zero means
+	// ReadTimeout is used
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout is how long reading the whole request including the body may take, zero means
+	// no timeout
+	ReadTimeout time.Duration
+	// WriteTimeout is how long handling the request and writing the response may take, it starts
+	// when the request is read. Zero means no timeout
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a persistent connection waits for the next request, zero means
+	// ReadTimeout is used
+	IdleTimeout time.Duration
+	// MaxRequestsPerConn is the maximum number of requests served on a single connection,
+	// zero means no limit
+	MaxRequestsPerConn int
+	// MaxHeaderBytes limits the size of the request line and headers, exceeding it is answered
+	// with 431 (or 414 for the request line). Zero means DEFAULT_MAX_HEADER_BYTES and a negative
+	// value means no limit, the same goes for the other limits
+	MaxHeaderBytes int
+	// MaxHeaderCount limits the number of request headers, exceeding it is answered with 431
+	MaxHeaderCount int
+	// MaxURILength limits the length of the request URI, exceeding it is answered with 414
+	MaxURILength int
+	// MaxBodyBytes limits the size of the request body, exceeding it is answered with 413
+	MaxBodyBytes int
+	// ServerName is sent in the Server header of every response, empty means no header
+	ServerName string
 }
 
+// default request limits, see [Option]
+const (
+	DEFAULT_MAX_HEADER_BYTES = 1 << 20
+	DEFAULT_MAX_HEADER_COUNT = 100
+	DEFAULT_MAX_URI_LENGTH   = 8 * 1024
+	DEFAULT_MAX_BODY_BYTES   = 10 << 20
+)
+
 type Server struct {
-	address  string
-	socket   net.Listener
+	address string
+	socket  net.Listener
+	router  *router
+	// ctx is the parent of every connection context, it's cancelled when the server is closed
+	ctx        context.Context
+	cancel     context.CancelFunc
+	mu         sync.Mutex
+	conns      map[*conn]struct{}
+	inShutdown atomic.Bool
+	// Handlers is the table of registered routes in registration order, use [Server.Handle] to
+	// add a route so it's also added to the router
 	Handlers []Handler
 	Option   Option
 }
@@ -42,144 +96,533 @@ func (s *Server) Use(middleware MiddlewareHandler) *Server {
 	return s
 }
 
+// Wrap adds a middleware that wraps every handler, see [Middleware]
+func (s *Server) Wrap(middleware Middleware) *Server {
+	s.Option.Middleware = append(s.Option.Middleware, middleware)
+
+	return s
+}
+
 func NewServer(address string, option Option) *Server {
 	// check err handler in option is nil
 	if option.ErrHandler == nil {
 		option.ErrHandler = func(req Request, err error) *Response {
 			slog.Error("Error while handling request", "ERROR", err)
 
+			if httpError, ok := err.(*HttpError); ok {
+				return &Response{
+					Code: httpError.Code,
+					Headers: Header{
+						"Content-Type": {"text/plain"},
+					},
+					Body: httpError.Msg,
+				}
+			}
+
 			return &Response{
 				Code: 500,
-				Headers: map[string]string{
-					"Content-Type": "text/plain",
+				Headers: Header{
+					"Content-Type": {"text/plain"},
 				},
 				Body: "Internal Server Error",
 			}
 		}
 	}
 
+	if option.MaxHeaderBytes == 0 {
+		option.MaxHeaderBytes = DEFAULT_MAX_HEADER_BYTES
+	}
+
+	if option.MaxHeaderCount == 0 {
+		option.MaxHeaderCount = DEFAULT_MAX_HEADER_COUNT
+	}
+
+	if option.MaxURILength == 0 {
+		option.MaxURILength = DEFAULT_MAX_URI_LENGTH
+	}
+
+	if option.MaxBodyBytes == 0 {
+		option.MaxBodyBytes = DEFAULT_MAX_BODY_BYTES
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &Server{
 		address: address,
 		Option:  option,
+		ctx:     ctx,
+		cancel:  cancel,
 	}
 }
 
+// ErrServerClosed is returned by [Server.ListenAndServe] after [Server.Shutdown] or [Server.Close]
+var ErrServerClosed = NewServerError("Server closed")
+
+// shutdownPollInterval is how often [Server.Shutdown] checks for finished connections
+const shutdownPollInterval = 50 * time.Millisecond
+
 func (s *Server) ListenAndServe() error {
+	if len(s.Handlers) == 0 {
+		return NewServerError("No handler found for the request")
+	}
+
 	socket, err := net.Listen("tcp", s.address)
 	if err != nil {
 		return NewServerError("Error while listening to address: " + err.Error())
 	}
 
+	s.mu.Lock()
+	if s.inShutdown.Load() {
+		s.mu.Unlock()
+		socket.Close()
+
+		return ErrServerClosed
+	}
+
+	s.socket = socket
+	s.mu.Unlock()
+
 	for {
-		conn, err := socket.Accept()
+		netConn, err := socket.Accept()
 		if err != nil {
-			request := Request{
-				Conn:    conn,
-				Path:    "",
-				Method:  "",
-				Version: "",
-				Headers: make(map[string]string),
-				Cookie:  make(map[string]string),
-				Body:    "Server Error",
+			if s.inShutdown.Load() {
+				return ErrServerClosed
 			}
 
-			response := s.Option.ErrHandler(request, NewServerError("Error while accepting connection"))
-			writeResponse(response, conn)
+			return NewServerError("Error while accepting connection: " + err.Error())
 		}
 
-		if len(s.Handlers) == 0 {
-			return NewServerError("No handler found for the request")
-		}
+		c := newConn(s.ctx, netConn)
+		s.trackConn(c, true)
 
-		go s.handleConnection(conn)
+		go s.handleConnection(c)
 	}
 }
 
+// Close immediately closes the listener and every connection, including websocket connections.
+// Use [Server.Shutdown] to let in-flight requests finish
 func (s *Server) Close() error {
-	return s.socket.Close()
+	s.inShutdown.Store(true)
+	s.cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if s.socket != nil {
+		err = s.socket.Close()
+	}
+
+	for c := range s.conns {
+		c.Close()
+		delete(s.conns, c)
+	}
+
+	return err
 }
 
-func (s *Server) handleConnection(conn net.Conn) {
-	defer conn.Close()
+// Shutdown stops accepting connections and waits for in-flight requests to finish. Idle
+// connections are closed and websocket connections are sent a 1001 Going Away close frame so
+// the clients can leave. When the context expires the remaining connections are closed and the
+// context error is returned
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.inShutdown.Store(true)
+
+	s.mu.Lock()
+	var err error
+	if s.socket != nil {
+		err = s.socket.Close()
+	}
+	s.mu.Unlock()
 
-	request, err := parseRequest(conn)
-	if err != nil {
-		response := s.Option.ErrHandler(Request{Conn: conn}, err)
-		writeResponse(response, conn)
-		return
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			s.Close()
+
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIdleConns closes idle connections, tells websocket clients the server is going away and
+// reports whether every connection is closed
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		switch c.state.Load() {
+		case stateIdle:
+			c.Close()
+			delete(s.conns, c)
+		case stateHijacked:
+			if !c.goingAway {
+				c.goingAway = true
+
+				// don't block the shutdown on a client that doesn't read
+				go c.Write(closeFrame("Server is shutting down", STATUS_CLOSE_GOING_AWAY))
+			}
+		}
+	}
+
+	return len(s.conns) == 0
+}
+
+func (s *Server) trackConn(c *conn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conns == nil {
+		s.conns = make(map[*conn]struct{})
 	}
 
+	if add {
+		s.conns[c] = struct{}{}
+	} else {
+		delete(s.conns, c)
+	}
+}
+
+func (s *Server) handleConnection(c *conn) {
+	defer s.trackConn(c, false)
+	defer c.cancel()
+	defer c.Close()
+	defer c.removeTempFiles()
+
+	// serve requests until the client or the server decides to close the connection, pipelined
+	// requests are read from the same buffer so their responses are written in order
+	for served := 1; ; served++ {
+		// the connection is idle until a request starts to arrive, so Shutdown can close it
+		c.state.Store(stateIdle)
+		if served > 1 && (s.inShutdown.Load() || !s.waitForRequest(c)) {
+			return
+		}
+
+		s.setReadDeadlines(c)
+
+		// the first request has no idle timeout, waiting for it is covered by the header timeout
+		if served == 1 {
+			if _, err := c.reader.Peek(1); err != nil {
+				return
+			}
+		}
+
+		c.state.Store(stateActive)
+
+		request, err := parseRequest(c, &s.Option)
+
+		// the deadline of the previous response may have passed, the next response gets its own
+		s.setWriteDeadline(c)
+
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			err = NewHttpError(408, "Request Timeout", request)
+		}
+
+		if _, ok := err.(*HttpError); err != nil && !ok {
+			// the client closed or reset the connection, or the server closed it, there is no one
+			// to answer
+			return
+		}
+
+		if err != nil {
+			// the stream can't be trusted anymore after a malformed request
+			response := s.Option.ErrHandler(request, err)
+			setConnectionHeader(response, request, false)
+			writeResponse(response, c.Conn, request, &s.Option)
+			return
+		}
+
+		// the request context is cancelled when the client goes away while the handler runs, the
+		// connection is only watched once the body is read since the handler reads it
+		ctx, cancel := context.WithCancel(c.ctx)
+		request.Context = ctx
+		request.body.whenRead(func() {
+			// the background read must not time out while the handler runs
+			c.SetReadDeadline(time.Time{})
+			c.startBackgroundRead(cancel)
+		})
+
+		response := s.handleRequest(request)
+		c.abortBackgroundRead()
+
+		// the connection is owned by someone else now, e.g. websocket
+		if c.hijacked {
+			cancel()
+			return
+		}
+
+		keepAlive := shouldKeepAlive(request) && !s.inShutdown.Load() &&
+			(s.Option.MaxRequestsPerConn <= 0 || served < s.Option.MaxRequestsPerConn)
+
+		// the next request starts after the body the handler didn't read
+		if keepAlive && !request.body.drain() {
+			keepAlive = false
+		}
+
+		keepAlive = setConnectionHeader(response, request, keepAlive)
+		err = writeResponse(response, c.Conn, request, &s.Option)
+		cancel()
+		c.removeTempFiles()
+
+		if err != nil || !keepAlive {
+			return
+		}
+	}
+}
+
+// setWriteDeadline sets the deadline for handling the request and writing the response, it's
+// cleared without WriteTimeout
+func (s *Server) setWriteDeadline(c *conn) {
+	if s.Option.WriteTimeout > 0 {
+		c.SetWriteDeadline(time.Now().Add(s.Option.WriteTimeout))
+	} else {
+		c.SetWriteDeadline(time.Time{})
+	}
+}
+
+// setReadDeadlines sets the deadline for reading the request headers, the deadline for the body
+// is applied by [parseRequest] once the headers are read
+func (s *Server) setReadDeadlines(c *conn) {
+	now := time.Now()
+
+	c.bodyDeadline = time.Time{}
+	if s.Option.ReadTimeout > 0 {
+		c.bodyDeadline = now.Add(s.Option.ReadTimeout)
+	}
+
+	headerDeadline := c.bodyDeadline
+	if s.Option.ReadHeaderTimeout > 0 {
+		deadline := now.Add(s.Option.ReadHeaderTimeout)
+		if headerDeadline.IsZero() || deadline.Before(headerDeadline) {
+			headerDeadline = deadline
+		}
+	}
+
+	c.SetReadDeadline(headerDeadline)
+}
+
+// waitForRequest blocks until the next request starts arriving or the idle timeout expires
+func (s *Server) waitForRequest(c *conn) bool {
+	timeout := s.Option.IdleTimeout
+	if timeout <= 0 {
+		timeout = s.Option.ReadTimeout
+	}
+
+	if timeout > 0 {
+		c.SetReadDeadline(time.Now().Add(timeout))
+		defer c.SetReadDeadline(time.Time{})
+	}
+
+	_, err := c.reader.Peek(1)
+
+	return err == nil
+}
+
+func (s *Server) handleRequest(request Request) *Response {
 	var response *Response
+	var err error
 
 	// find the handler for the request
-	for _, handler := range s.Handlers {
-		if request.Path == handler.Path {
-			// check if method is not same, if method is "", call the handler instead
-			if handler.Method != request.Method && handler.Method != "" {
-				response = s.Option.ErrHandler(request, NewHttpError(405, "Method not allowed", request))
-				break
+	rawPath := request.RawPath
+	if rawPath == "" {
+		rawPath = request.Path
+	}
+
+	// "OPTIONS *" asks about the server instead of a resource
+	if request.Method == "OPTIONS" && rawPath == "*" {
+		response = NewResponse()
+		response.SetCode(204)
+		response.AddHeader("Allow", s.allowedMethods())
+
+		return response
+	}
+
+	var route *node
+	if s.router != nil {
+		route, request.Params = s.router.lookup(rawPath)
+	}
+
+	if route == nil {
+		return s.Option.ErrHandler(request, NewHttpError(404, "No handler found for the request", request))
+	}
+
+	// prefer the handler of the request method, the handler without method accepts any method
+	index, ok := route.handlers[request.Method]
+
+	// HEAD is served by the GET handler unless it has its own handler, the handler still sees
+	// the HEAD method and the body is dropped when the response is written
+	if !ok && request.Method == "HEAD" {
+		index, ok = route.handlers["GET"]
+	}
+
+	if !ok {
+		index, ok = route.handlers[""]
+	}
+
+	if !ok {
+		allow := route.allowedMethods()
+
+		// answer OPTIONS from the registered methods unless a handler is registered for it
+		if request.Method == "OPTIONS" {
+			response = NewResponse()
+			response.SetCode(204)
+			response.AddHeader("Allow", allow)
+
+			return response
+		}
+
+		response = s.Option.ErrHandler(request, NewHttpError(405, "Method not allowed", request))
+		if response != nil {
+			if response.Headers == nil {
+				response.Headers = make(Header)
 			}
 
-			func() {
-				defer func() {
-					rc := recover()
+			response.Headers.Set("Allow", allow)
+		}
 
-					// check if error is not nil
-					if rc != nil {
-						err = rc.(error)
-					}
+		return response
+	}
 
-				}()
+	handler := s.Handlers[index]
 
-				// run global middleware
-				for _, middleware := range s.Option.GlobalMiddleware {
-					middleware(request)
-				}
+	// global middleware, then group middleware from the outermost group, then route middleware
+	middleware := adaptMiddleware(s.Option.GlobalMiddleware)
+	middleware = append(middleware, s.Option.Middleware...)
+	middleware = append(middleware, handler.group.middlewares()...)
+	middleware = append(middleware, adaptMiddleware(handler.Middleware)...)
 
-				// run middleware
-				for _, middleware := range handler.Middleware {
-					middleware(request)
+	func() {
+		defer func() {
+			rc := recover()
+
+			// check if error is not nil
+			if rc != nil {
+				var ok bool
+				if err, ok = rc.(error); !ok {
+					err = NewHandlingError(fmt.Sprint(rc))
 				}
+			}
 
-				response = handler.Handler(request)
-			}()
+		}()
 
-			break
-		}
+		response = Chain(handler.Handler, middleware...)(request)
+	}()
+
+	if err == nil && response != nil && response.err != nil {
+		err = response.err
 	}
 
 	if err != nil {
 		response = s.Option.ErrHandler(request, err)
 	}
 
-	if response == nil {
+	// a hijacked connection doesn't need a response
+	if response == nil && (request.conn == nil || !request.conn.hijacked) {
 		response = s.Option.ErrHandler(request, NewHttpError(404, "No handler found for the request", request))
 	}
 
-	writeResponse(response, conn)
+	return response
+}
+
+// allowedMethods returns the methods of every route for the Allow header of "OPTIONS *"
+func (s *Server) allowedMethods() string {
+	all := &node{handlers: make(map[string]int)}
+	for i, handler := range s.Handlers {
+		if handler.Method != "" {
+			all.handlers[handler.Method] = i
+		}
+	}
+
+	return all.allowedMethods()
+}
+
+// setConnectionHeader tells the client whether the connection stays open after the response,
+// a handler can force the connection to close by setting "Connection: close" itself
+func setConnectionHeader(response *Response, request Request, keepAlive bool) bool {
+	if response == nil {
+		return keepAlive
+	}
+
+	if response.Headers == nil {
+		response.Headers = make(Header)
+	}
+
+	if hasToken(response.Headers.Get("Connection"), "close") {
+		keepAlive = false
+	}
+
+	// HTTP/1.0 has no chunked encoding, a body of unknown length ends with the connection
+	if response.Reader != nil && request.Version == "HTTP/1.0" && !response.Headers.Has("Content-Length") {
+		keepAlive = false
+	}
+
+	if !keepAlive {
+		response.Headers.Set("Connection", "close")
+	} else if request.Version == "HTTP/1.0" {
+		response.Headers.Set("Connection", "keep-alive")
+	}
+
+	return keepAlive
 }
 
 // Handle registers a handler for the given path
 // The middleware its different with global middleware, its not run for all request
+//
+// A path segment starting with ":" captures a single segment and a last segment starting with "*"
+// captures the rest of the path, the values are available from [Request.Param]
+//
+//	server.Handle("GET /users/:id", ...)
+//	server.Handle("GET /static/*filepath", ...)
+//
+// When several paths match a request, static segments take precedence over parameters and
+// parameters over wildcards
+//
+// A path can be registered once per method, a request with another method is answered with 405
+// and an Allow header, OPTIONS is answered automatically unless it has its own handler
+//
+// HEAD requests are served by the GET handler without the body, register a HEAD handler for the
+// path to handle it differently
 func (s *Server) Handle(
 	path string,
 	handler ResponseHandler,
 	middleware ...MiddlewareHandler,
 ) error {
-	// check duplicate path
-	for _, h := range s.Handlers {
-		if h.Path == path {
-			return NewServerError("Duplicate path found")
-		}
+	method, path := parsePath(path)
+
+	return s.handle(method, path, handler, middleware, nil)
+}
+
+func (s *Server) handle(
+	method string,
+	path string,
+	handler ResponseHandler,
+	middleware []MiddlewareHandler,
+	group *Group,
+) error {
+	if s.router == nil {
+		s.router = newRouter()
 	}
 
-	method, path := parsePath(path)
+	err := s.router.insert(method, path, len(s.Handlers))
+	if err != nil {
+		return err
+	}
 
 	res := Handler{
 		Path:       path,
 		Handler:    handler,
 		Method:     method,
 		Middleware: make([]MiddlewareHandler, 0),
+		group:      group,
 	}
 
 	res.Middleware = append(res.Middleware, middleware...)
@@ -202,13 +645,7 @@ func (s *Server) ServeFile(path string, filePath string) error {
 	fileType := http.DetectContentType(file)
 
 	return s.Handle(path, func(req Request) *Response {
-		return &Response{
-			Code: 200,
-			Headers: map[string]string{
-				"Content-Type": fileType,
-			},
-			Body: string(file),
-		}
+		return NewBytesResponse(fileType, file)
 	})
 }
 
@@ -245,13 +682,7 @@ func (s *Server) ServeDir(prefixPath string, filePath string) error {
 		fileType := http.DetectContentType(output)
 
 		err = s.Handle(prefixPath+"/"+file.Name(), func(req Request) *Response {
-			return &Response{
-				Code: 200,
-				Headers: map[string]string{
-					"Content-Type": fileType,
-				},
-				Body: string(output),
-			}
+			return NewBytesResponse(fileType, output)
 		})
 
 		if err != nil {
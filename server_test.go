@@ -0,0 +1,478 @@
+package hfs
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServer serves the routes on a loopback port, the server is closed with the test
+func startServer(t *testing.T, option Option, routes func(s *Server)) (*Server, string) {
+	t.Helper()
+
+	s := NewServer("127.0.0.1:0", option)
+	routes(s)
+
+	address, _ := serve(t, s)
+
+	return s, address
+}
+
+// serve runs ListenAndServe until the test ends, its error is sent on the channel
+func serve(t *testing.T, s *Server) (string, <-chan error) {
+	t.Helper()
+
+	done := make(chan error, 1)
+	go func() { done <- s.ListenAndServe() }()
+	t.Cleanup(func() { s.Close() })
+
+	for i := 0; i < 100; i++ {
+		s.mu.Lock()
+		socket := s.socket
+		s.mu.Unlock()
+
+		if socket != nil {
+			return socket.Addr().String(), done
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("server didn't start")
+
+	return "", nil
+}
+
+// dial connects to the server, reads of the returned reader fail after two seconds
+func dial(t *testing.T, address string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	c, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { c.Close() })
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	return c, bufio.NewReader(c)
+}
+
+// readResponse reads a response and its body
+func readResponse(t *testing.T, reader *bufio.Reader, method string) (*http.Response, string) {
+	t.Helper()
+
+	response, err := http.ReadResponse(reader, &http.Request{Method: method})
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+
+	defer response.Body.Close()
+
+	var body []byte
+	buf := make([]byte, 512)
+	for {
+		n, err := response.Body.Read(buf)
+		body = append(body, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+
+	return response, string(body)
+}
+
+func textHandler(text string) ResponseHandler {
+	return func(Request) *Response {
+		return NewTextResponse(text)
+	}
+}
+
+func TestWriteTimeoutResetForErrorResponse(t *testing.T) {
+	option := Option{ReadHeaderTimeout: 200 * time.Millisecond, WriteTimeout: 50 * time.Millisecond}
+	_, address := startServer(t, option, func(s *Server) {
+		s.Handle("/", textHandler("ok"))
+	})
+
+	c, reader := dial(t, address)
+	c.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	if response, _ := readResponse(t, reader, "GET"); response.StatusCode != 200 {
+		t.Fatalf("first response = %d, want 200", response.StatusCode)
+	}
+
+	// the write deadline of the first response passes before the second request times out
+	time.Sleep(100 * time.Millisecond)
+	c.Write([]byte("GET / HTTP/1.1\r\n"))
+
+	if response, _ := readResponse(t, reader, "GET"); response.StatusCode != 408 {
+		t.Errorf("second response = %d, want 408", response.StatusCode)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	_, address := startServer(t, Option{}, func(s *Server) {
+		s.Handle("GET /users", textHandler("users"))
+		s.Handle("POST /users", textHandler("created"))
+		s.Handle("DELETE /users/:id", textHandler("deleted"))
+	})
+
+	tests := []struct {
+		target string
+		code   int
+		allow  string
+	}{
+		{"*", 204, "DELETE, GET, HEAD, OPTIONS, POST"},
+		{"/users", 204, "GET, HEAD, OPTIONS, POST"},
+		{"/users/1", 204, "DELETE, OPTIONS"},
+		{"/missing", 404, ""},
+	}
+
+	c, reader := dial(t, address)
+	for _, test := range tests {
+		c.Write([]byte("OPTIONS " + test.target + " HTTP/1.1\r\nHost: x\r\n\r\n"))
+
+		response, _ := readResponse(t, reader, "OPTIONS")
+		if response.StatusCode != test.code || response.Header.Get("Allow") != test.allow {
+			t.Errorf("OPTIONS %s = %d %q, want %d %q",
+				test.target, response.StatusCode, response.Header.Get("Allow"), test.code, test.allow)
+		}
+	}
+}
+
+// expectClosed checks that the server closed the connection without sending more
+func expectClosed(t *testing.T, reader *bufio.Reader) {
+	t.Helper()
+
+	if b, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("read after close = %q, %v, want EOF", b, err)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		header  string
+		// close is whether the first response closes the connection
+		close bool
+	}{
+		{"HTTP/1.1", "HTTP/1.1", "", false},
+		{"HTTP/1.1 close", "HTTP/1.1", "Connection: close\r\n", true},
+		{"HTTP/1.0", "HTTP/1.0", "", true},
+		{"HTTP/1.0 keep-alive", "HTTP/1.0", "Connection: keep-alive\r\n", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, address := startServer(t, Option{}, func(s *Server) {
+				s.Handle("/", textHandler("ok"))
+			})
+
+			c, reader := dial(t, address)
+			request := "GET / " + test.version + "\r\nHost: x\r\n" + test.header + "\r\n"
+
+			c.Write([]byte(request))
+			response, body := readResponse(t, reader, "GET")
+			if response.StatusCode != 200 || body != "ok" {
+				t.Fatalf("response = %d %q, want 200 \"ok\"", response.StatusCode, body)
+			}
+
+			if response.Close != test.close {
+				t.Fatalf("close = %v, want %v", response.Close, test.close)
+			}
+
+			if test.close {
+				expectClosed(t, reader)
+				return
+			}
+
+			c.Write([]byte(request))
+			if response, _ := readResponse(t, reader, "GET"); response.StatusCode != 200 {
+				t.Errorf("second response = %d, want 200", response.StatusCode)
+			}
+		})
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	_, address := startServer(t, Option{}, func(s *Server) {
+		s.Handle("/slow", func(Request) *Response {
+			time.Sleep(50 * time.Millisecond)
+			return NewTextResponse("slow")
+		})
+		s.Handle("/echo", func(r Request) *Response {
+			body, err := r.ReadBody()
+			if err != nil {
+				return NewErrorResponse(err)
+			}
+
+			return NewTextResponse(string(body))
+		})
+		s.Handle("/fast", textHandler("fast"))
+	})
+
+	c, reader := dial(t, address)
+
+	// every request is sent before the first response, the unread body of /fast is skipped
+	c.Write([]byte(
+		"GET /slow HTTP/1.1\r\nHost: x\r\n\r\n" +
+			"POST /echo HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello" +
+			"POST /fast HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n" +
+			"GET /fast HTTP/1.1\r\nHost: x\r\n\r\n",
+	))
+
+	for _, want := range []string{"slow", "hello", "fast", "fast"} {
+		response, body := readResponse(t, reader, "GET")
+		if response.StatusCode != 200 || body != want {
+			t.Fatalf("response = %d %q, want 200 %q", response.StatusCode, body, want)
+		}
+	}
+}
+
+func TestMaxRequestsPerConn(t *testing.T) {
+	_, address := startServer(t, Option{MaxRequestsPerConn: 2}, func(s *Server) {
+		s.Handle("/", textHandler("ok"))
+	})
+
+	c, reader := dial(t, address)
+	for i := 1; i <= 2; i++ {
+		c.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+
+		response, _ := readResponse(t, reader, "GET")
+		if response.Close != (i == 2) {
+			t.Fatalf("response %d close = %v, want %v", i, response.Close, i == 2)
+		}
+	}
+
+	expectClosed(t, reader)
+}
+
+func TestConnectionErrorsAreNotAnswered(t *testing.T) {
+	handled := make(chan error, 1)
+	option := Option{
+		ErrHandler: func(request Request, err error) *Response {
+			handled <- err
+			return NewTextResponse("error")
+		},
+	}
+
+	_, address := startServer(t, option, func(s *Server) {
+		s.Handle("/", textHandler("ok"))
+	})
+
+	// the client goes away in the middle of the headers
+	c, _ := dial(t, address)
+	c.Write([]byte("GET / HTTP/1.1\r\nHo"))
+	c.(*net.TCPConn).SetLinger(0)
+	c.Close()
+
+	// a malformed request is still answered
+	c, reader := dial(t, address)
+	c.Write([]byte("GET / HTTP/1.1\r\nHost x\r\n\r\n"))
+	readResponse(t, reader, "GET")
+
+	if err := <-handled; errorCode(err) != 400 {
+		t.Errorf("error handler got %v, want the 400 of the malformed request", err)
+	}
+
+	select {
+	case err := <-handled:
+		t.Errorf("error handler got %v, want only the malformed request", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	s := NewServer("127.0.0.1:0", Option{})
+	s.Handle("/", func(Request) *Response {
+		close(started)
+		<-release
+
+		return NewTextResponse("done")
+	})
+	s.Handle("/idle", textHandler("idle"))
+
+	address, done := serve(t, s)
+
+	// a busy connection, an idle keep-alive connection and one that never sent a request
+	busy, busyReader := dial(t, address)
+	busy.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	<-started
+
+	idle, idleReader := dial(t, address)
+	idle.Write([]byte("GET /idle HTTP/1.1\r\nHost: x\r\n\r\n"))
+	readResponse(t, idleReader, "GET")
+
+	_, silentReader := dial(t, address)
+	time.Sleep(20 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	expectClosed(t, idleReader)
+	expectClosed(t, silentReader)
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v before the request finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+
+	response, body := readResponse(t, busyReader, "GET")
+	if body != "done" || !response.Close {
+		t.Errorf("response = %q close %v, want \"done\" with the connection closed", body, response.Close)
+	}
+
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown = %v, want nil", err)
+	}
+
+	if err := <-done; err != ErrServerClosed {
+		t.Errorf("ListenAndServe = %v, want ErrServerClosed", err)
+	}
+
+	if _, err := net.Dial("tcp", address); err == nil {
+		t.Error("server still accepts connections")
+	}
+}
+
+func TestShutdownContextExpires(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	s, address := startServer(t, Option{}, func(s *Server) {
+		s.Handle("/", func(r Request) *Response {
+			<-release
+			return NewTextResponse("done")
+		})
+	})
+
+	c, reader := dial(t, address)
+	c.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown = %v, want context.DeadlineExceeded", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took %v", elapsed)
+	}
+
+	// the remaining connections are closed
+	expectClosed(t, reader)
+}
+
+func TestShutdownWithoutConnections(t *testing.T) {
+	s, _ := startServer(t, Option{}, func(s *Server) {
+		s.Handle("/", textHandler("ok"))
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown = %v, want nil", err)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	tests := []struct {
+		name   string
+		option Option
+		// requests are written one by one, a response is read after the ones ending with CRLFCRLF
+		requests []string
+		// code is the status of the last response, zero means the connection is closed without one
+		code int
+	}{
+		{
+			name:     "header timeout",
+			option:   Option{ReadHeaderTimeout: 100 * time.Millisecond},
+			requests: []string{"GET / HTTP/1.1\r\nHost: x\r\n"},
+			code:     408,
+		},
+		{
+			name:     "read timeout covers the headers",
+			option:   Option{ReadTimeout: 100 * time.Millisecond},
+			requests: []string{"GET / HTTP/1.1\r\n"},
+			code:     408,
+		},
+		{
+			name:     "no request before the header timeout",
+			option:   Option{ReadHeaderTimeout: 100 * time.Millisecond},
+			requests: []string{""},
+		},
+		{
+			name:     "idle timeout",
+			option:   Option{IdleTimeout: 100 * time.Millisecond, ReadHeaderTimeout: time.Second},
+			requests: []string{"GET / HTTP/1.1\r\nHost: x\r\n\r\n", ""},
+		},
+		{
+			name:     "idle timeout defaults to the read timeout",
+			option:   Option{ReadTimeout: 100 * time.Millisecond},
+			requests: []string{"GET / HTTP/1.1\r\nHost: x\r\n\r\n", ""},
+		},
+		{
+			name:     "write timeout",
+			option:   Option{WriteTimeout: 50 * time.Millisecond},
+			requests: []string{"GET /slow HTTP/1.1\r\nHost: x\r\n\r\n"},
+		},
+		{
+			name:     "write timeout restarts for every request",
+			option:   Option{WriteTimeout: 100 * time.Millisecond},
+			requests: []string{"GET / HTTP/1.1\r\nHost: x\r\n\r\n", "GET / HTTP/1.1\r\nHost: x\r\n\r\n"},
+			code:     200,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, address := startServer(t, test.option, func(s *Server) {
+				s.Handle("/", textHandler("ok"))
+				s.Handle("/slow", func(Request) *Response {
+					time.Sleep(150 * time.Millisecond)
+					return NewTextResponse(strings.Repeat("a", 1<<20))
+				})
+			})
+
+			c, reader := dial(t, address)
+
+			for i, request := range test.requests {
+				// the second request comes after the write deadline of the first response
+				if i > 0 {
+					time.Sleep(150 * time.Millisecond)
+				}
+
+				c.Write([]byte(request))
+
+				last := i == len(test.requests)-1
+				if !last && strings.HasSuffix(request, "\r\n\r\n") {
+					readResponse(t, reader, "GET")
+				}
+			}
+
+			if test.code == 0 {
+				expectClosed(t, reader)
+				return
+			}
+
+			if response, _ := readResponse(t, reader, "GET"); response.StatusCode != test.code {
+				t.Errorf("status = %d, want %d", response.StatusCode, test.code)
+			}
+		})
+	}
+}
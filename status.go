@@ -0,0 +1,124 @@
+package hfs
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+var statusText = map[int]string{
+	100: "Continue",
+	101: "Switching Protocols",
+	102: "Processing",
+	103: "Early Hints",
+
+	200: "OK",
+	201: "Created",
+	202: "Accepted",
+	203: "Non-Authoritative Information",
+	204: "No Content",
+	205: "Reset Content",
+	206: "Partial Content",
+	207: "Multi-Status",
+	208: "Already Reported",
+	226: "IM Used",
+
+	300: "Multiple Choices",
+	301: "Moved Permanently",
+	302: "Found",
+	303: "See Other",
+	304: "Not Modified",
+	305: "Use Proxy",
+	307: "Temporary Redirect",
+	308: "Permanent Redirect",
+
+	400: "Bad Request",
+	401: "Unauthorized",
+	402: "Payment Required",
+	403: "Forbidden",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	406: "Not Acceptable",
+	407: "Proxy Authentication Required",
+	408: "Request Timeout",
+	409: "Conflict",
+	410: "Gone",
+	411: "Length Required",
+	412: "Precondition Failed",
+	413: "Content Too Large",
+	414: "URI Too Long",
+	415: "Unsupported Media Type",
+	416: "Range Not Satisfiable",
+	417: "Expectation Failed",
+	418: "I'm a teapot",
+	421: "Misdirected Request",
+	422: "Unprocessable Content",
+	423: "Locked",
+	424: "Failed Dependency",
+	425: "Too Early",
+	426: "Upgrade Required",
+	428: "Precondition Required",
+	429: "Too Many Requests",
+	431: "Request Header Fields Too Large",
+	451: "Unavailable For Legal Reasons",
+
+	500: "Internal Server Error",
+	501: "Not Implemented",
+	502: "Bad Gateway",
+	503: "Service Unavailable",
+	504: "Gateway Timeout",
+	505: "HTTP Version Not Supported",
+	506: "Variant Also Negotiates",
+	507: "Insufficient Storage",
+	508: "Loop Detected",
+	510: "Not Extended",
+	511: "Network Authentication Required",
+}
+
+// StatusText returns the reason phrase of the status code, empty for unknown codes
+func StatusText(code int) string {
+	return statusText[code]
+}
+
+// appendStatusLine appends the status line of the response, HTTP/1.0 clients get their version
+// back
+func appendStatusLine(b []byte, version string, code int) []byte {
+	if version != "HTTP/1.0" {
+		version = "HTTP/1.1"
+	}
+
+	b = append(b, version...)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(code), 10)
+	b = append(b, ' ')
+	b = append(b, StatusText(code)...)
+
+	return append(b, "\r\n"...)
+}
+
+// bodyAllowed reports whether a response with the status code can have a body
+func bodyAllowed(code int) bool {
+	return code >= 200 && code != 204 && code != 304
+}
+
+// the Date header only changes every second, formatting it for every response is wasted work
+var date struct {
+	mu     sync.Mutex
+	second int64
+	value  string
+}
+
+// httpDate returns the current time for the Date header
+func httpDate() string {
+	now := time.Now()
+
+	date.mu.Lock()
+	defer date.mu.Unlock()
+
+	if now.Unix() != date.second {
+		date.second = now.Unix()
+		date.value = now.UTC().Format(TIME_FORMAT)
+	}
+
+	return date.value
+}
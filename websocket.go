@@ -4,6 +4,7 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/binary"
+	"io"
 	"net"
 )
 
@@ -52,6 +53,8 @@ type Websocket struct {
 type Client struct {
 	Conn   net.Conn
 	option *WSOption
+	// reader holds the bytes the server read ahead before the upgrade, it reads from Conn after
+	reader io.Reader
 }
 
 type WSOption struct {
@@ -92,22 +95,20 @@ func NewWebsocket(option *WSOption) (ws Websocket) {
 // Sec-WebSocket-Protocol: chat
 
 func (ws *Websocket) Upgrade(request Request) (client Client, err error) {
-	key := ""
-
-	if _, ok := request.Headers["sec-websocket-key"]; ok {
-		key = request.Headers["sec-websocket-key"]
-	}
-
-	if _, ok := request.Headers["Sec-WebSocket-Key"]; ok {
-		key = request.Headers["Sec-WebSocket-Key"]
-	}
-
+	key := request.Headers.Get("Sec-WebSocket-Key")
 	if key == "" {
 		return client, NewWsError("Sec-WebSocket-Key is required")
 	}
 
 	acceptKey := generateWebsocketKey(key)
 
+	// the server must stop reading the connection before the client can send frames, the frames
+	// it already buffered are read through the buffered reader
+	if request.conn != nil {
+		request.conn.hijack()
+		client.reader = request.conn.reader
+	}
+
 	_, err = request.Conn.Write([]byte(
 		"HTTP/1.1 101 Switching Protocols\r\n" +
 			"Upgrade: websocket\r\n" +
@@ -162,7 +163,12 @@ func (client *Client) SendWithMessageType(msg string, messageType MessageType) e
 func (client *Client) Read() ([]byte, error) {
 	buf := make([]byte, client.option.MsgMaxSize)
 
-	n, err := client.Conn.Read(buf)
+	reader := io.Reader(client.Conn)
+	if client.reader != nil {
+		reader = client.reader
+	}
+
+	n, err := reader.Read(buf)
 	if err != nil {
 		return nil, NewWsError("Error reading message : " + err.Error())
 	}
@@ -182,18 +188,7 @@ func (client *Client) Read() ([]byte, error) {
 
 func (client *Client) Close(reason string, code int) error {
 	// send close normal closue
-	closeMSG := make([]byte, 0)
-
-	// add status code on the first 2 byte
-	closeMSG = append(closeMSG, byte(code>>8))
-	closeMSG = append(closeMSG, byte(code&0xFF))
-
-	// add reason
-	closeMSG = append(closeMSG, []byte(reason)...)
-
-	frame := encodeFrame(closeMSG, CLOSE)
-
-	_, err := client.Conn.Write(frame)
+	_, err := client.Conn.Write(closeFrame(reason, code))
 	if err != nil {
 		return NewWsError("Error sending close signal : " + err.Error())
 	}
@@ -206,6 +201,20 @@ func (client *Client) Close(reason string, code int) error {
 	return nil
 }
 
+// closeFrame encodes a close frame with the status code and reason
+func closeFrame(reason string, code int) []byte {
+	closeMSG := make([]byte, 0)
+
+	// add status code on the first 2 byte
+	closeMSG = append(closeMSG, byte(code>>8))
+	closeMSG = append(closeMSG, byte(code&0xFF))
+
+	// add reason
+	closeMSG = append(closeMSG, []byte(reason)...)
+
+	return encodeFrame(closeMSG, CLOSE)
+}
+
 func generateWebsocketKey(key string) string {
 	sha := sha1.New()
 	sha.Write([]byte(key))